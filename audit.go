@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// auditOptions holds the flags accepted by the audit subcommand.
+type auditOptions struct {
+	BucketPrefix string
+	Exec         executorOptions
+	AWS          awsOptions
+}
+
+// runAudit checks every backup bucket of the account against the hardening baseline and fails
+// when one of them does not meet it.
+func runAudit(args []string) error {
+	var opts auditOptions
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.StringVar(&opts.BucketPrefix, "bucket-prefix", backupBucketPrefix, "audit the buckets whose name starts with this prefix")
+	opts.Exec.register(fs)
+	opts.AWS.register(fs)
+	fs.Parse(args)
+	if err := opts.Exec.install(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	awsc, err := newAWSClient(ctx, defaultAWSRegion, opts.AWS, opts.Exec)
+	if err != nil {
+		return err
+	}
+	if _, _, err := awsc.PrintIdentity(ctx); err != nil {
+		return err
+	}
+	buckets, err := awsc.ListBuckets(ctx, opts.BucketPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list the buckets: %w", err)
+	}
+
+	fmt.Printf("\n%-60s %s\n", "BUCKET", "RESULT")
+	failed := 0
+	for _, bucket := range buckets {
+		bucketClient, err := awsc.forBucket(ctx, bucket)
+		if err != nil {
+			return err
+		}
+		findings, err := auditBucket(ctx, bucketClient, bucket)
+		if err != nil {
+			return err
+		}
+		if len(findings) == 0 {
+			fmt.Printf("%-60s %s\n", bucket, "PASS")
+			continue
+		}
+		failed++
+		fmt.Printf("%-60s FAIL: %s\n", bucket, strings.Join(findings, "; "))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d buckets do not meet the hardening baseline, run harden for their clusters", failed, len(buckets))
+	}
+	return nil
+}
@@ -0,0 +1,1305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+// AWS error codes drtest handles explicitly.
+const (
+	errCodeEntityAlreadyExists     = "EntityAlreadyExists"
+	errCodeNoSuchEntity            = "NoSuchEntity"
+	errCodeNoSuchBucket            = "NoSuchBucket"
+	errCodeNoSuchKey               = "NoSuchKey"
+	errCodeBucketAlreadyOwnedByYou = "BucketAlreadyOwnedByYou"
+	errCodeDeleteConflict          = "DeleteConflict"
+	errCodeKMSNotFound             = "NotFoundException"
+	errCodeKMSAccessDenied         = "AccessDeniedException"
+	errCodeKMSInvalidState         = "KMSInvalidStateException"
+
+	errCodeNoSuchPublicAccessBlock       = "NoSuchPublicAccessBlockConfiguration"
+	errCodeOwnershipControlsNotFound     = "OwnershipControlsNotFoundError"
+	errCodeNoSuchBucketPolicy            = "NoSuchBucketPolicy"
+	errCodeEncryptionConfigurationAbsent = "ServerSideEncryptionConfigurationNotFoundError"
+	errCodeObjectLockConfigurationAbsent = "ObjectLockConfigurationNotFoundError"
+	errCodeNoSuchObjectLockConfiguration = "NoSuchObjectLockConfiguration"
+	errCodeNoSuchTagSet                  = "NoSuchTagSet"
+)
+
+// defaultAWSRegion is used for the global IAM calls when no region is given. It is also the
+// default S3 location, whose buckets are created and reported without a location constraint.
+const defaultAWSRegion = "us-east-1"
+
+// isAWSErrorCode reports whether err is an AWS API error with the given error code.
+func isAWSErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
+
+// isBucketNotFound reports whether err says a bucket does not exist, either from an S3 call or from
+// the region lookup of forBucket.
+func isBucketNotFound(err error) bool {
+	var notFound manager.BucketNotFound
+	return isAWSErrorCode(err, errCodeNoSuchBucket) || errors.As(err, &notFound)
+}
+
+// awsOptions holds the flags that configure the AWS client.
+type awsOptions struct {
+	Profile       string
+	AssumeRoleArn string
+	ExternalID    string
+	Endpoint      string
+}
+
+// register adds the AWS flags to a subcommand's flag set.
+func (o *awsOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Profile, "aws-profile", "", "AWS profile from your local aws config, e.g. dr-account; defaults to the default credential chain")
+	fs.StringVar(&o.AssumeRoleArn, "aws-assume-role-arn", "", "role to assume with the credentials of --aws-profile for every AWS call")
+	fs.StringVar(&o.ExternalID, "aws-external-id", "", "external ID required by the trust policy of --aws-assume-role-arn")
+	fs.StringVar(&o.Endpoint, "aws-endpoint-url", "", "send every S3, IAM, KMS and STS call to this endpoint, e.g. a local emulator")
+}
+
+// awsClient is the typed layer over the S3, IAM, KMS and STS SDK clients used by drtest.
+// In dry-run mode every call is printed as part of the plan and nothing is sent to AWS.
+type awsClient struct {
+	cfg      aws.Config
+	opts     awsOptions
+	endpoint string
+	dryRun   *dryRunExecutor
+	s3       *s3.Client
+	iam      *iam.Client
+	kms      *kms.Client
+	sts      *sts.Client
+}
+
+// newAWSClient loads the AWS configuration for region and builds the SDK clients.
+// Every call uses the credentials of --aws-profile, or of the role assumed with them.
+// With --record or --replay the HTTP traffic goes through httpTransport.
+func newAWSClient(ctx context.Context, region string, opts awsOptions, execOpts executorOptions) (*awsClient, error) {
+	if dryRun, ok := executor.(*dryRunExecutor); ok {
+		return &awsClient{cfg: aws.Config{Region: region}, opts: opts, dryRun: dryRun}, nil
+	}
+
+	loadOpts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+	}
+	if execOpts.Replay != "" {
+		// Replayed requests are never sent, so no real credentials are needed to sign them.
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("replay", "replay", "")))
+	} else if opts.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(opts.Profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if opts.Endpoint != "" {
+		cfg.BaseEndpoint = aws.String(opts.Endpoint)
+	}
+	if opts.AssumeRoleArn != "" && execOpts.Replay == "" {
+		// Built before httpTransport is set, so the temporary credentials never end up in a transcript.
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.AssumeRoleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "drtest"
+			if opts.ExternalID != "" {
+				o.ExternalID = aws.String(opts.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	if execOpts.Record != "" || execOpts.Replay != "" {
+		// Set after loading, the SDK's own client must stay buildable to honour AWS_CA_BUNDLE.
+		cfg.HTTPClient = &http.Client{Transport: httpTransport}
+	}
+	return newAWSClientFromConfig(cfg, opts), nil
+}
+
+// newAWSClientFromConfig builds the SDK clients from a loaded configuration.
+func newAWSClientFromConfig(cfg aws.Config, opts awsOptions) *awsClient {
+	return &awsClient{
+		cfg:      cfg,
+		opts:     opts,
+		endpoint: opts.Endpoint,
+		s3: s3.NewFromConfig(cfg, func(o *s3.Options) {
+			// Local emulators rarely support virtual hosted bucket addressing.
+			o.UsePathStyle = opts.Endpoint != ""
+		}),
+		iam: iam.NewFromConfig(cfg),
+		kms: kms.NewFromConfig(cfg),
+		sts: sts.NewFromConfig(cfg),
+	}
+}
+
+// withRegion returns a client with the same credentials and endpoint for another region.
+func (c *awsClient) withRegion(region string) *awsClient {
+	if region == c.cfg.Region {
+		return c
+	}
+	if c.dryRun != nil {
+		return &awsClient{cfg: aws.Config{Region: region}, opts: c.opts, dryRun: c.dryRun}
+	}
+	cfg := c.cfg.Copy()
+	cfg.Region = region
+	return newAWSClientFromConfig(cfg, c.opts)
+}
+
+// forBucket returns a client for the region the bucket lives in.
+func (c *awsClient) forBucket(ctx context.Context, bucket string) (*awsClient, error) {
+	if c.dryRun != nil || c.endpoint != "" {
+		return c, nil
+	}
+	region, err := manager.GetBucketRegion(ctx, c.s3, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the region of bucket %s: %w", bucket, err)
+	}
+	return c.withRegion(region), nil
+}
+
+// plan prints a planned call in dry-run mode and returns its placeholder output.
+func (c *awsClient) plan(operation string, args ...string) string {
+	return c.dryRun.plan("aws", append([]string{operation}, args...)...)
+}
+
+// CreateBucket creates an S3 bucket in the client's region. With objectLock the bucket is
+// created with S3 Object Lock enabled, which cannot be turned off later.
+func (c *awsClient) CreateBucket(ctx context.Context, bucket string, objectLock bool) error {
+	if c.dryRun != nil {
+		c.plan("s3:CreateBucket", "bucket="+bucket, "region="+c.cfg.Region, fmt.Sprintf("object-lock=%t", objectLock))
+		return nil
+	}
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucket), ObjectLockEnabledForBucket: aws.Bool(objectLock)}
+	// The default location rejects an explicit constraint.
+	if c.cfg.Region != defaultAWSRegion {
+		input.CreateBucketConfiguration = &s3types.CreateBucketConfiguration{
+			LocationConstraint: s3types.BucketLocationConstraint(c.cfg.Region),
+		}
+	}
+	_, err := c.s3.CreateBucket(ctx, input)
+	return err
+}
+
+// purgeBatchSize is the most objects a single DeleteObjects call accepts.
+const purgeBatchSize = 1000
+
+// purgeResult counts what PurgeBucket deleted: object versions and delete markers, the bytes
+// they held, and the versions S3 refused to delete.
+type purgeResult struct {
+	Deleted int
+	Bytes   int64
+	Failed  int
+}
+
+// purgeBatch is the work of one DeleteObjects call.
+type purgeBatch struct {
+	objects []s3types.ObjectIdentifier
+	sizes   map[string]int64
+	bytes   int64
+}
+
+// PurgeBucket deletes every object version and delete marker of a bucket, except those keep
+// returns true for. The versions are listed page by page and deleted in batches by parallel
+// workers; progress is called after every batch with the running totals. Versions S3 refuses
+// to delete, e.g. under an Object Lock retention, are counted as failed and the others are still
+// deleted; the error then names the first of them.
+func (c *awsClient) PurgeBucket(ctx context.Context, bucket string, workers int, keep func(key, versionID string) bool, progress func(purgeResult)) (purgeResult, error) {
+	var result purgeResult
+	if c.dryRun != nil {
+		c.plan("s3:DeleteObjects", "bucket="+bucket, "objects=all-versions", fmt.Sprintf("workers=%d", workers))
+		return result, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan purgeBatch)
+	var listErr error
+	go func() {
+		defer close(batches)
+		listErr = c.listPurgeBatches(ctx, bucket, keep, batches)
+	}()
+
+	var mu sync.Mutex
+	var deleteErr error
+	var failure string
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				done, firstFailure, err := c.deleteBatch(ctx, bucket, batch)
+				mu.Lock()
+				if err != nil && deleteErr == nil {
+					// The listing stops too, the remaining batches would fail the same way.
+					deleteErr = err
+					cancel()
+				}
+				if failure == "" {
+					failure = firstFailure
+				}
+				result.Deleted += done.Deleted
+				result.Bytes += done.Bytes
+				result.Failed += done.Failed
+				if err == nil && progress != nil {
+					progress(result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	switch {
+	case deleteErr != nil:
+		return result, deleteErr
+	case listErr != nil:
+		return result, listErr
+	case result.Failed > 0:
+		return result, fmt.Errorf("%d object versions could not be deleted, the first: %s", result.Failed, failure)
+	}
+	return result, nil
+}
+
+// listPurgeBatches pages through the versions and delete markers of a bucket and sends the ones
+// keep does not return true for in batches of at most purgeBatchSize objects.
+func (c *awsClient) listPurgeBatches(ctx context.Context, bucket string, keep func(key, versionID string) bool, batches chan<- purgeBatch) error {
+	batch := purgeBatch{sizes: map[string]int64{}}
+	send := func() error {
+		if len(batch.objects) == 0 {
+			return nil
+		}
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		batch = purgeBatch{sizes: map[string]int64{}}
+		return nil
+	}
+	add := func(key, versionID *string, size int64) error {
+		if keep != nil && keep(aws.ToString(key), aws.ToString(versionID)) {
+			return nil
+		}
+		batch.objects = append(batch.objects, s3types.ObjectIdentifier{Key: key, VersionId: versionID})
+		batch.sizes[aws.ToString(key)+"\x00"+aws.ToString(versionID)] = size
+		batch.bytes += size
+		if len(batch.objects) == purgeBatchSize {
+			return send()
+		}
+		return nil
+	}
+
+	paginator := s3.NewListObjectVersionsPaginator(c.s3, &s3.ListObjectVersionsInput{Bucket: aws.String(bucket)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, v := range page.Versions {
+			if err := add(v.Key, v.VersionId, aws.ToInt64(v.Size)); err != nil {
+				return err
+			}
+		}
+		for _, m := range page.DeleteMarkers {
+			if err := add(m.Key, m.VersionId, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return send()
+}
+
+// deleteBatch deletes the objects of a batch with one DeleteObjects call. It returns what was
+// deleted and a description of the first object S3 refused to delete.
+func (c *awsClient) deleteBatch(ctx context.Context, bucket string, batch purgeBatch) (purgeResult, string, error) {
+	out, err := c.s3.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3types.Delete{Objects: batch.objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		return purgeResult{}, "", err
+	}
+	done := purgeResult{Deleted: len(batch.objects) - len(out.Errors), Bytes: batch.bytes, Failed: len(out.Errors)}
+	var failure string
+	for _, e := range out.Errors {
+		done.Bytes -= batch.sizes[aws.ToString(e.Key)+"\x00"+aws.ToString(e.VersionId)]
+		if failure == "" {
+			failure = fmt.Sprintf("%s (version %s): %s", aws.ToString(e.Key), aws.ToString(e.VersionId), aws.ToString(e.Message))
+		}
+	}
+	return done, failure, nil
+}
+
+// DeleteObjectVersions deletes every version and delete marker of one object, so a versioned
+// bucket keeps no trace of it. With bypassGovernance, versions under a governance mode retention
+// are deleted too.
+func (c *awsClient) DeleteObjectVersions(ctx context.Context, bucket, key string, bypassGovernance bool) error {
+	if c.dryRun != nil {
+		c.plan("s3:DeleteObjects", "bucket="+bucket, "key="+key, "objects=all-versions", fmt.Sprintf("bypass-governance=%t", bypassGovernance))
+		return nil
+	}
+	_, err := c.deleteVersions(ctx, bucket, key, bypassGovernance, func(k, _ string) bool { return k == key })
+	return err
+}
+
+// deleteVersions deletes the object versions and delete markers under prefix that match.
+func (c *awsClient) deleteVersions(ctx context.Context, bucket, prefix string, bypassGovernance bool, match func(key, versionID string) bool) (int, error) {
+	deleted := 0
+	paginator := s3.NewListObjectVersionsPaginator(c.s3, &s3.ListObjectVersionsInput{Bucket: aws.String(bucket), Prefix: aws.String(prefix)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		var objects []s3types.ObjectIdentifier
+		for _, v := range page.Versions {
+			if match(aws.ToString(v.Key), aws.ToString(v.VersionId)) {
+				objects = append(objects, s3types.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+			}
+		}
+		for _, m := range page.DeleteMarkers {
+			if match(aws.ToString(m.Key), aws.ToString(m.VersionId)) {
+				objects = append(objects, s3types.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+			}
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		input := &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		}
+		if bypassGovernance {
+			input.BypassGovernanceRetention = aws.Bool(true)
+		}
+		out, err := c.s3.DeleteObjects(ctx, input)
+		if err != nil {
+			return deleted, err
+		}
+		if len(out.Errors) > 0 {
+			return deleted, fmt.Errorf("failed to delete %s: %s", aws.ToString(out.Errors[0].Key), aws.ToString(out.Errors[0].Message))
+		}
+		deleted += len(objects)
+	}
+	return deleted, nil
+}
+
+// PutBucketReplication replicates the SSE-KMS encrypted objects under prefix of a bucket to
+// destBucket, encrypting the replicas with destKeyArn. Delete markers are replicated too, so the
+// replica shows the same backups as the source.
+func (c *awsClient) PutBucketReplication(ctx context.Context, bucket, roleArn, ruleID, prefix, destBucket, destKeyArn string) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutBucketReplication", "bucket="+bucket, "role="+roleArn, "rule="+ruleID, "prefix="+prefix, "destination="+destBucket, "destination-key="+destKeyArn)
+		return nil
+	}
+	_, err := c.s3.PutBucketReplication(ctx, &s3.PutBucketReplicationInput{
+		Bucket: aws.String(bucket),
+		ReplicationConfiguration: &s3types.ReplicationConfiguration{
+			Role: aws.String(roleArn),
+			Rules: []s3types.ReplicationRule{{
+				ID:                      aws.String(ruleID),
+				Status:                  s3types.ReplicationRuleStatusEnabled,
+				Priority:                aws.Int32(1),
+				Filter:                  &s3types.ReplicationRuleFilter{Prefix: aws.String(prefix)},
+				DeleteMarkerReplication: &s3types.DeleteMarkerReplication{Status: s3types.DeleteMarkerReplicationStatusEnabled},
+				SourceSelectionCriteria: &s3types.SourceSelectionCriteria{
+					SseKmsEncryptedObjects: &s3types.SseKmsEncryptedObjects{Status: s3types.SseKmsEncryptedObjectsStatusEnabled},
+				},
+				Destination: &s3types.Destination{
+					Bucket:                  aws.String("arn:aws:s3:::" + destBucket),
+					EncryptionConfiguration: &s3types.EncryptionConfiguration{ReplicaKmsKeyID: aws.String(destKeyArn)},
+				},
+			}},
+		},
+	})
+	return err
+}
+
+// DeleteBucketReplication removes the replication configuration of a bucket.
+func (c *awsClient) DeleteBucketReplication(ctx context.Context, bucket string) error {
+	if c.dryRun != nil {
+		c.plan("s3:DeleteBucketReplication", "bucket="+bucket)
+		return nil
+	}
+	_, err := c.s3.DeleteBucketReplication(ctx, &s3.DeleteBucketReplicationInput{Bucket: aws.String(bucket)})
+	return err
+}
+
+// PutObjectLockConfiguration sets the default retention of an Object Lock enabled bucket.
+func (c *awsClient) PutObjectLockConfiguration(ctx context.Context, bucket, mode string, days int32) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutObjectLockConfiguration", "bucket="+bucket, "mode="+mode, fmt.Sprintf("days=%d", days))
+		return nil
+	}
+	_, err := c.s3.PutObjectLockConfiguration(ctx, &s3.PutObjectLockConfigurationInput{
+		Bucket: aws.String(bucket),
+		ObjectLockConfiguration: &s3types.ObjectLockConfiguration{
+			ObjectLockEnabled: s3types.ObjectLockEnabledEnabled,
+			Rule: &s3types.ObjectLockRule{
+				DefaultRetention: &s3types.DefaultRetention{
+					Mode: s3types.ObjectLockRetentionMode(mode),
+					Days: aws.Int32(days),
+				},
+			},
+		},
+	})
+	return err
+}
+
+// ObjectLockEnabled reports whether a bucket has S3 Object Lock enabled.
+func (c *awsClient) ObjectLockEnabled(ctx context.Context, bucket string) (bool, error) {
+	if c.dryRun != nil {
+		c.plan("s3:GetObjectLockConfiguration", "bucket="+bucket)
+		return false, nil
+	}
+	out, err := c.s3.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{Bucket: aws.String(bucket)})
+	if isAWSErrorCode(err, errCodeObjectLockConfigurationAbsent) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return out.ObjectLockConfiguration != nil && out.ObjectLockConfiguration.ObjectLockEnabled == s3types.ObjectLockEnabledEnabled, nil
+}
+
+// lockedObject is an object version that Object Lock keeps from being deleted.
+type lockedObject struct {
+	Key         string
+	VersionID   string
+	Mode        string
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+// LockedObjects returns the object versions of a bucket that are under retention or legal hold.
+func (c *awsClient) LockedObjects(ctx context.Context, bucket string) ([]lockedObject, error) {
+	if c.dryRun != nil {
+		c.plan("s3:GetObjectRetention", "bucket="+bucket, "objects=all-versions")
+		return nil, nil
+	}
+	now := time.Now()
+	var locked []lockedObject
+	paginator := s3.NewListObjectVersionsPaginator(c.s3, &s3.ListObjectVersionsInput{Bucket: aws.String(bucket)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page.Versions {
+			obj := lockedObject{Key: aws.ToString(v.Key), VersionID: aws.ToString(v.VersionId)}
+			retention, err := c.s3.GetObjectRetention(ctx, &s3.GetObjectRetentionInput{Bucket: aws.String(bucket), Key: v.Key, VersionId: v.VersionId})
+			switch {
+			case isAWSErrorCode(err, errCodeNoSuchObjectLockConfiguration):
+			case err != nil:
+				return nil, fmt.Errorf("failed to get the retention of %s: %w", obj.Key, err)
+			case retention.Retention != nil && aws.ToTime(retention.Retention.RetainUntilDate).After(now):
+				obj.Mode = string(retention.Retention.Mode)
+				obj.RetainUntil = aws.ToTime(retention.Retention.RetainUntilDate)
+			}
+			hold, err := c.s3.GetObjectLegalHold(ctx, &s3.GetObjectLegalHoldInput{Bucket: aws.String(bucket), Key: v.Key, VersionId: v.VersionId})
+			switch {
+			case isAWSErrorCode(err, errCodeNoSuchObjectLockConfiguration):
+			case err != nil:
+				return nil, fmt.Errorf("failed to get the legal hold of %s: %w", obj.Key, err)
+			case hold.LegalHold != nil && hold.LegalHold.Status == s3types.ObjectLockLegalHoldStatusOn:
+				obj.LegalHold = true
+			}
+			if obj.Mode != "" || obj.LegalHold {
+				locked = append(locked, obj)
+			}
+		}
+	}
+	return locked, nil
+}
+
+// ListBuckets returns the names of the account's buckets that start with prefix.
+func (c *awsClient) ListBuckets(ctx context.Context, prefix string) ([]string, error) {
+	if c.dryRun != nil {
+		return []string{c.plan("s3:ListBuckets", "prefix="+prefix)}, nil
+	}
+	var names []string
+	paginator := s3.NewListBucketsPaginator(c.s3, &s3.ListBucketsInput{Prefix: aws.String(prefix)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, bucket := range page.Buckets {
+			names = append(names, aws.ToString(bucket.Name))
+		}
+	}
+	return names, nil
+}
+
+// PutPublicAccessBlock blocks every form of public access to a bucket.
+func (c *awsClient) PutPublicAccessBlock(ctx context.Context, bucket string) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutPublicAccessBlock", "bucket="+bucket, "block-all=true")
+		return nil
+	}
+	_, err := c.s3.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(bucket),
+		PublicAccessBlockConfiguration: &s3types.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	return err
+}
+
+// PublicAccessBlocked reports whether every form of public access to a bucket is blocked.
+func (c *awsClient) PublicAccessBlocked(ctx context.Context, bucket string) (bool, error) {
+	if c.dryRun != nil {
+		c.plan("s3:GetPublicAccessBlock", "bucket="+bucket)
+		return true, nil
+	}
+	out, err := c.s3.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+	if isAWSErrorCode(err, errCodeNoSuchPublicAccessBlock) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	cfg := out.PublicAccessBlockConfiguration
+	return aws.ToBool(cfg.BlockPublicAcls) && aws.ToBool(cfg.IgnorePublicAcls) &&
+		aws.ToBool(cfg.BlockPublicPolicy) && aws.ToBool(cfg.RestrictPublicBuckets), nil
+}
+
+// PutBucketOwnershipEnforced disables ACLs on a bucket, so the bucket owner owns every object.
+func (c *awsClient) PutBucketOwnershipEnforced(ctx context.Context, bucket string) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutBucketOwnershipControls", "bucket="+bucket, "ownership=BucketOwnerEnforced")
+		return nil
+	}
+	_, err := c.s3.PutBucketOwnershipControls(ctx, &s3.PutBucketOwnershipControlsInput{
+		Bucket: aws.String(bucket),
+		OwnershipControls: &s3types.OwnershipControls{
+			Rules: []s3types.OwnershipControlsRule{{ObjectOwnership: s3types.ObjectOwnershipBucketOwnerEnforced}},
+		},
+	})
+	return err
+}
+
+// BucketOwnership returns the object ownership setting of a bucket, or "" when it has none.
+func (c *awsClient) BucketOwnership(ctx context.Context, bucket string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("s3:GetBucketOwnershipControls", "bucket="+bucket), nil
+	}
+	out, err := c.s3.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{Bucket: aws.String(bucket)})
+	if isAWSErrorCode(err, errCodeOwnershipControlsNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(out.OwnershipControls.Rules) == 0 {
+		return "", nil
+	}
+	return string(out.OwnershipControls.Rules[0].ObjectOwnership), nil
+}
+
+// EnableVersioning turns on versioning for a bucket.
+func (c *awsClient) EnableVersioning(ctx context.Context, bucket string) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutBucketVersioning", "bucket="+bucket, "status=Enabled")
+		return nil
+	}
+	_, err := c.s3.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &s3types.VersioningConfiguration{Status: s3types.BucketVersioningStatusEnabled},
+	})
+	return err
+}
+
+// BucketVersioning returns the versioning status of a bucket, "" when it was never enabled.
+func (c *awsClient) BucketVersioning(ctx context.Context, bucket string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("s3:GetBucketVersioning", "bucket="+bucket), nil
+	}
+	out, err := c.s3.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return "", err
+	}
+	return string(out.Status), nil
+}
+
+// PutBucketPolicy sets the bucket policy of a bucket.
+func (c *awsClient) PutBucketPolicy(ctx context.Context, bucket, policy string) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutBucketPolicy", "bucket="+bucket, "policy="+policy)
+		return nil
+	}
+	_, err := c.s3.PutBucketPolicy(ctx, &s3.PutBucketPolicyInput{Bucket: aws.String(bucket), Policy: aws.String(policy)})
+	return err
+}
+
+// BucketPolicy returns the bucket policy of a bucket, or "" when it has none.
+func (c *awsClient) BucketPolicy(ctx context.Context, bucket string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("s3:GetBucketPolicy", "bucket="+bucket), nil
+	}
+	out, err := c.s3.GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{Bucket: aws.String(bucket)})
+	if isAWSErrorCode(err, errCodeNoSuchBucketPolicy) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Policy), nil
+}
+
+// BucketTags returns the tags of a bucket, an empty map when it has none.
+func (c *awsClient) BucketTags(ctx context.Context, bucket string) (map[string]string, error) {
+	tags := map[string]string{}
+	if c.dryRun != nil {
+		c.plan("s3:GetBucketTagging", "bucket="+bucket)
+		return tags, nil
+	}
+	out, err := c.s3.GetBucketTagging(ctx, &s3.GetBucketTaggingInput{Bucket: aws.String(bucket)})
+	if isAWSErrorCode(err, errCodeNoSuchTagSet) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range out.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+// BucketEncryption returns the default encryption algorithm and KMS key of a bucket, or "" when it has none.
+func (c *awsClient) BucketEncryption(ctx context.Context, bucket string) (string, string, error) {
+	if c.dryRun != nil {
+		out := c.plan("s3:GetBucketEncryption", "bucket="+bucket)
+		return out, out, nil
+	}
+	out, err := c.s3.GetBucketEncryption(ctx, &s3.GetBucketEncryptionInput{Bucket: aws.String(bucket)})
+	if isAWSErrorCode(err, errCodeEncryptionConfigurationAbsent) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	for _, rule := range out.ServerSideEncryptionConfiguration.Rules {
+		if def := rule.ApplyServerSideEncryptionByDefault; def != nil {
+			return string(def.SSEAlgorithm), aws.ToString(def.KMSMasterKeyID), nil
+		}
+	}
+	return "", "", nil
+}
+
+// BucketRegion returns the region an S3 bucket is located in.
+func (c *awsClient) BucketRegion(ctx context.Context, bucket string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("s3:GetBucketLocation", "bucket="+bucket), nil
+	}
+	out, err := c.s3.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return "", err
+	}
+	// Buckets in the default location report an empty location constraint, and the oldest ones in
+	// eu-west-1 report EU.
+	switch out.LocationConstraint {
+	case "":
+		return defaultAWSRegion, nil
+	case s3types.BucketLocationConstraintEu:
+		return "eu-west-1", nil
+	}
+	return string(out.LocationConstraint), nil
+}
+
+// DeleteBucket deletes an empty S3 bucket.
+func (c *awsClient) DeleteBucket(ctx context.Context, bucket string) error {
+	if c.dryRun != nil {
+		c.plan("s3:DeleteBucket", "bucket="+bucket)
+		return nil
+	}
+	_, err := c.s3.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucket)})
+	return err
+}
+
+// GetObject returns the content of an S3 object.
+func (c *awsClient) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	if c.dryRun != nil {
+		return []byte(c.plan("s3:GetObject", "bucket="+bucket, "key="+key)), nil
+	}
+	out, err := c.s3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// PutObject uploads content to an S3 object.
+func (c *awsClient) PutObject(ctx context.Context, bucket, key string, content []byte) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutObject", "bucket="+bucket, "key="+key)
+		return nil
+	}
+	_, err := c.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	return err
+}
+
+// PutBucketEncryption makes SSE-KMS with keyArn the default encryption of a bucket, with an
+// S3 bucket key so objects do not each need a call to KMS.
+func (c *awsClient) PutBucketEncryption(ctx context.Context, bucket, keyArn string) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutBucketEncryption", "bucket="+bucket, "sse=aws:kms", "key="+keyArn, "bucket-key=true")
+		return nil
+	}
+	_, err := c.s3.PutBucketEncryption(ctx, &s3.PutBucketEncryptionInput{
+		Bucket: aws.String(bucket),
+		ServerSideEncryptionConfiguration: &s3types.ServerSideEncryptionConfiguration{
+			Rules: []s3types.ServerSideEncryptionRule{{
+				ApplyServerSideEncryptionByDefault: &s3types.ServerSideEncryptionByDefault{
+					SSEAlgorithm:   s3types.ServerSideEncryptionAwsKms,
+					KMSMasterKeyID: aws.String(keyArn),
+				},
+				BucketKeyEnabled: aws.Bool(true),
+			}},
+		},
+	})
+	return err
+}
+
+// ObjectEncryption returns the server-side encryption algorithm and KMS key ID of an S3 object.
+func (c *awsClient) ObjectEncryption(ctx context.Context, bucket, key string) (string, string, error) {
+	if c.dryRun != nil {
+		out := c.plan("s3:HeadObject", "bucket="+bucket, "key="+key)
+		return out, out, nil
+	}
+	out, err := c.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return "", "", err
+	}
+	return string(out.ServerSideEncryption), aws.ToString(out.SSEKMSKeyId), nil
+}
+
+// PutObjectRetained uploads content to an S3 object under a governance mode retention until the
+// given time, overriding the default retention of the bucket.
+func (c *awsClient) PutObjectRetained(ctx context.Context, bucket, key string, content []byte, until time.Time) error {
+	if c.dryRun != nil {
+		c.plan("s3:PutObject", "bucket="+bucket, "key="+key, "retention=GOVERNANCE", "retain-until="+until.Format(time.RFC3339))
+		return nil
+	}
+	_, err := c.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(key),
+		Body:                      bytes.NewReader(content),
+		ObjectLockMode:            s3types.ObjectLockModeGovernance,
+		ObjectLockRetainUntilDate: aws.Time(until),
+	})
+	return err
+}
+
+// DeleteObject deletes an S3 object.
+func (c *awsClient) DeleteObject(ctx context.Context, bucket, key string) error {
+	if c.dryRun != nil {
+		c.plan("s3:DeleteObject", "bucket="+bucket, "key="+key)
+		return nil
+	}
+	_, err := c.s3.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	return err
+}
+
+// CreateRole creates an IAM role with the given trust policy and returns its ARN.
+func (c *awsClient) CreateRole(ctx context.Context, roleName, trustPolicy, description string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("iam:CreateRole", "role="+roleName, "trust-policy="+trustPolicy), nil
+	}
+	out, err := c.iam.CreateRole(ctx, &iam.CreateRoleInput{
+		RoleName:                 aws.String(roleName),
+		AssumeRolePolicyDocument: aws.String(trustPolicy),
+		Description:              aws.String(description),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Role.Arn), nil
+}
+
+// GetRoleArn returns the ARN of an existing IAM role.
+func (c *awsClient) GetRoleArn(ctx context.Context, roleName string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("iam:GetRole", "role="+roleName), nil
+	}
+	out, err := c.iam.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Role.Arn), nil
+}
+
+// DeleteRole deletes an IAM role. Its policies must be detached first.
+func (c *awsClient) DeleteRole(ctx context.Context, roleName string) error {
+	if c.dryRun != nil {
+		c.plan("iam:DeleteRole", "role="+roleName)
+		return nil
+	}
+	_, err := c.iam.DeleteRole(ctx, &iam.DeleteRoleInput{RoleName: aws.String(roleName)})
+	return err
+}
+
+// AttachRolePolicy attaches a managed policy to a role.
+func (c *awsClient) AttachRolePolicy(ctx context.Context, roleName, policyArn string) error {
+	if c.dryRun != nil {
+		c.plan("iam:AttachRolePolicy", "role="+roleName, "policy="+policyArn)
+		return nil
+	}
+	_, err := c.iam.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+		RoleName:  aws.String(roleName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+// DetachRolePolicy detaches a managed policy from a role.
+func (c *awsClient) DetachRolePolicy(ctx context.Context, roleName, policyArn string) error {
+	if c.dryRun != nil {
+		c.plan("iam:DetachRolePolicy", "role="+roleName, "policy="+policyArn)
+		return nil
+	}
+	_, err := c.iam.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
+		RoleName:  aws.String(roleName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+// PutRolePolicy creates or replaces an inline policy of a role.
+func (c *awsClient) PutRolePolicy(ctx context.Context, roleName, policyName, document string) error {
+	if c.dryRun != nil {
+		c.plan("iam:PutRolePolicy", "role="+roleName, "name="+policyName, "policy="+document)
+		return nil
+	}
+	_, err := c.iam.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(document),
+	})
+	return err
+}
+
+// DeleteRolePolicy deletes an inline policy of a role.
+func (c *awsClient) DeleteRolePolicy(ctx context.Context, roleName, policyName string) error {
+	if c.dryRun != nil {
+		c.plan("iam:DeleteRolePolicy", "role="+roleName, "name="+policyName)
+		return nil
+	}
+	_, err := c.iam.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(policyName),
+	})
+	return err
+}
+
+// ListRolePolicies returns the names of the inline policies of a role.
+func (c *awsClient) ListRolePolicies(ctx context.Context, roleName string) ([]string, error) {
+	if c.dryRun != nil {
+		return []string{c.plan("iam:ListRolePolicies", "role="+roleName)}, nil
+	}
+	var names []string
+	paginator := iam.NewListRolePoliciesPaginator(c.iam, &iam.ListRolePoliciesInput{RoleName: aws.String(roleName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, page.PolicyNames...)
+	}
+	return names, nil
+}
+
+// ListAttachedRolePolicies returns the ARNs of the managed policies attached to a role.
+func (c *awsClient) ListAttachedRolePolicies(ctx context.Context, roleName string) ([]string, error) {
+	if c.dryRun != nil {
+		return []string{c.plan("iam:ListAttachedRolePolicies", "role="+roleName)}, nil
+	}
+	var arns []string
+	paginator := iam.NewListAttachedRolePoliciesPaginator(c.iam, &iam.ListAttachedRolePoliciesInput{RoleName: aws.String(roleName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, policy := range page.AttachedPolicies {
+			arns = append(arns, aws.ToString(policy.PolicyArn))
+		}
+	}
+	return arns, nil
+}
+
+// ListInstanceProfilesForRole returns the names of the instance profiles a role is part of.
+func (c *awsClient) ListInstanceProfilesForRole(ctx context.Context, roleName string) ([]string, error) {
+	if c.dryRun != nil {
+		return []string{c.plan("iam:ListInstanceProfilesForRole", "role="+roleName)}, nil
+	}
+	var names []string
+	paginator := iam.NewListInstanceProfilesForRolePaginator(c.iam, &iam.ListInstanceProfilesForRoleInput{RoleName: aws.String(roleName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, profile := range page.InstanceProfiles {
+			names = append(names, aws.ToString(profile.InstanceProfileName))
+		}
+	}
+	return names, nil
+}
+
+// RoleTags returns the tags of a role.
+func (c *awsClient) RoleTags(ctx context.Context, roleName string) (map[string]string, error) {
+	tags := map[string]string{}
+	if c.dryRun != nil {
+		c.plan("iam:ListRoleTags", "role="+roleName)
+		return tags, nil
+	}
+	paginator := iam.NewListRoleTagsPaginator(c.iam, &iam.ListRoleTagsInput{RoleName: aws.String(roleName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range page.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return tags, nil
+}
+
+// RemoveRoleFromInstanceProfile removes a role from an instance profile.
+func (c *awsClient) RemoveRoleFromInstanceProfile(ctx context.Context, profileName, roleName string) error {
+	if c.dryRun != nil {
+		c.plan("iam:RemoveRoleFromInstanceProfile", "profile="+profileName, "role="+roleName)
+		return nil
+	}
+	_, err := c.iam.RemoveRoleFromInstanceProfile(ctx, &iam.RemoveRoleFromInstanceProfileInput{
+		InstanceProfileName: aws.String(profileName),
+		RoleName:            aws.String(roleName),
+	})
+	return err
+}
+
+// FindOIDCProvider returns the ARN of the IAM OIDC provider whose URL is exactly issuer,
+// given without the https:// scheme, or "" when the account has none.
+func (c *awsClient) FindOIDCProvider(ctx context.Context, issuer string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("iam:ListOpenIDConnectProviders", "url="+issuer), nil
+	}
+	out, err := c.iam.ListOpenIDConnectProviders(ctx, &iam.ListOpenIDConnectProvidersInput{})
+	if err != nil {
+		return "", err
+	}
+	for _, provider := range out.OpenIDConnectProviderList {
+		// The ARN of a provider is its URL without the scheme, after the resource type.
+		arn := aws.ToString(provider.Arn)
+		if _, url, ok := strings.Cut(arn, ":oidc-provider/"); ok && url == issuer {
+			return arn, nil
+		}
+	}
+	return "", nil
+}
+
+// CreateOIDCProvider registers an OIDC issuer with IAM and returns the ARN of the provider.
+// Without thumbprints IAM retrieves the certificate of the issuer itself.
+func (c *awsClient) CreateOIDCProvider(ctx context.Context, issuerURL string, clientIDs, thumbprints []string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("iam:CreateOpenIDConnectProvider", "url="+issuerURL, "client-ids="+strings.Join(clientIDs, ","), "thumbprints="+strings.Join(thumbprints, ",")), nil
+	}
+	out, err := c.iam.CreateOpenIDConnectProvider(ctx, &iam.CreateOpenIDConnectProviderInput{
+		Url:            aws.String(issuerURL),
+		ClientIDList:   clientIDs,
+		ThumbprintList: thumbprints,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.OpenIDConnectProviderArn), nil
+}
+
+// DeleteOIDCProvider deletes an IAM OIDC provider.
+func (c *awsClient) DeleteOIDCProvider(ctx context.Context, providerArn string) error {
+	if c.dryRun != nil {
+		c.plan("iam:DeleteOpenIDConnectProvider", "provider="+providerArn)
+		return nil
+	}
+	_, err := c.iam.DeleteOpenIDConnectProvider(ctx, &iam.DeleteOpenIDConnectProviderInput{OpenIDConnectProviderArn: aws.String(providerArn)})
+	return err
+}
+
+// CreatePolicy creates a customer managed IAM policy with tags and returns its ARN.
+func (c *awsClient) CreatePolicy(ctx context.Context, policyName, document string, tags map[string]string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("iam:CreatePolicy", "policy="+policyName, "document="+document), nil
+	}
+	input := &iam.CreatePolicyInput{
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(document),
+	}
+	for key, value := range tags {
+		input.Tags = append(input.Tags, iamtypes.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	out, err := c.iam.CreatePolicy(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Policy.Arn), nil
+}
+
+// DeletePolicy deletes a customer managed IAM policy.
+func (c *awsClient) DeletePolicy(ctx context.Context, policyArn string) error {
+	if c.dryRun != nil {
+		c.plan("iam:DeletePolicy", "policy="+policyArn)
+		return nil
+	}
+	_, err := c.iam.DeletePolicy(ctx, &iam.DeletePolicyInput{PolicyArn: aws.String(policyArn)})
+	return err
+}
+
+// ListPolicyVersions returns the IDs of the versions of a managed policy other than its default one.
+// A policy can only be deleted once they are gone.
+func (c *awsClient) ListPolicyVersions(ctx context.Context, policyArn string) ([]string, error) {
+	if c.dryRun != nil {
+		return []string{c.plan("iam:ListPolicyVersions", "policy="+policyArn)}, nil
+	}
+	var versions []string
+	paginator := iam.NewListPolicyVersionsPaginator(c.iam, &iam.ListPolicyVersionsInput{PolicyArn: aws.String(policyArn)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range page.Versions {
+			if !version.IsDefaultVersion {
+				versions = append(versions, aws.ToString(version.VersionId))
+			}
+		}
+	}
+	return versions, nil
+}
+
+// DeletePolicyVersion deletes a version of a managed policy other than its default one.
+func (c *awsClient) DeletePolicyVersion(ctx context.Context, policyArn, versionID string) error {
+	if c.dryRun != nil {
+		c.plan("iam:DeletePolicyVersion", "policy="+policyArn, "version="+versionID)
+		return nil
+	}
+	_, err := c.iam.DeletePolicyVersion(ctx, &iam.DeletePolicyVersionInput{
+		PolicyArn: aws.String(policyArn),
+		VersionId: aws.String(versionID),
+	})
+	return err
+}
+
+// FindPoliciesByTag returns the ARNs of the customer managed policies whose name starts with
+// namePrefix that are tagged key=value.
+func (c *awsClient) FindPoliciesByTag(ctx context.Context, namePrefix, key, value string) ([]string, error) {
+	if c.dryRun != nil {
+		return []string{c.plan("iam:ListPolicies", "scope=Local", "prefix="+namePrefix, "tag="+key+"="+value)}, nil
+	}
+	var arns []string
+	paginator := iam.NewListPoliciesPaginator(c.iam, &iam.ListPoliciesInput{Scope: iamtypes.PolicyScopeTypeLocal})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// ListPolicies leaves the tags out, they are read policy by policy for the matching names only.
+		for _, policy := range page.Policies {
+			if !strings.HasPrefix(aws.ToString(policy.PolicyName), namePrefix) {
+				continue
+			}
+			tags, err := c.iam.ListPolicyTags(ctx, &iam.ListPolicyTagsInput{PolicyArn: policy.Arn})
+			if err != nil {
+				return nil, err
+			}
+			for _, tag := range tags.Tags {
+				if aws.ToString(tag.Key) == key && aws.ToString(tag.Value) == value {
+					arns = append(arns, aws.ToString(policy.Arn))
+					break
+				}
+			}
+		}
+	}
+	return arns, nil
+}
+
+// CreateKey creates a symmetric encryption KMS key with the given tags and returns its ARN.
+func (c *awsClient) CreateKey(ctx context.Context, description string, tags map[string]string) (string, error) {
+	if c.dryRun != nil {
+		return c.plan("kms:CreateKey", "description="+description, "region="+c.cfg.Region), nil
+	}
+	input := &kms.CreateKeyInput{
+		Description: aws.String(description),
+		KeyUsage:    kmstypes.KeyUsageTypeEncryptDecrypt,
+		KeySpec:     kmstypes.KeySpecSymmetricDefault,
+	}
+	for key, value := range tags {
+		input.Tags = append(input.Tags, kmstypes.Tag{TagKey: aws.String(key), TagValue: aws.String(value)})
+	}
+	out, err := c.kms.CreateKey(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.KeyMetadata.Arn), nil
+}
+
+// PutKeyPolicy replaces the default key policy of a KMS key.
+func (c *awsClient) PutKeyPolicy(ctx context.Context, keyID, policy string) error {
+	if c.dryRun != nil {
+		c.plan("kms:PutKeyPolicy", "key="+keyID, "policy="+policy)
+		return nil
+	}
+	_, err := c.kms.PutKeyPolicy(ctx, &kms.PutKeyPolicyInput{
+		KeyId:      aws.String(keyID),
+		PolicyName: aws.String("default"),
+		Policy:     aws.String(policy),
+	})
+	return err
+}
+
+// KMS keys are deleted after a waiting period of 7 to 30 days, during which the deletion can be cancelled.
+const (
+	minKeyPendingDays = 7
+	maxKeyPendingDays = 30
+)
+
+// ScheduleKeyDeletion schedules the deletion of a KMS key after the waiting period in days and
+// returns the date it will be deleted on.
+func (c *awsClient) ScheduleKeyDeletion(ctx context.Context, keyID string, pendingDays int32) (time.Time, error) {
+	if c.dryRun != nil {
+		c.plan("kms:ScheduleKeyDeletion", "key="+keyID, fmt.Sprintf("pending-days=%d", pendingDays))
+		return time.Now().AddDate(0, 0, int(pendingDays)), nil
+	}
+	out, err := c.kms.ScheduleKeyDeletion(ctx, &kms.ScheduleKeyDeletionInput{
+		KeyId:               aws.String(keyID),
+		PendingWindowInDays: aws.Int32(pendingDays),
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return aws.ToTime(out.DeletionDate), nil
+}
+
+// FindKeysByTag returns the ARNs of the KMS keys of the client's region tagged key=value that
+// are not already pending deletion.
+func (c *awsClient) FindKeysByTag(ctx context.Context, key, value string) ([]string, error) {
+	if c.dryRun != nil {
+		return []string{c.plan("kms:ListKeys", "region="+c.cfg.Region, "tag="+key+"="+value)}, nil
+	}
+	var arns []string
+	paginator := kms.NewListKeysPaginator(c.kms, &kms.ListKeysInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range page.Keys {
+			// AWS managed keys and keys of other principals may refuse the tag listing, they are not ours.
+			// Any other error, e.g. throttling, could hide one of our keys and is returned.
+			tags, err := c.kms.ListResourceTags(ctx, &kms.ListResourceTagsInput{KeyId: entry.KeyId})
+			if isAWSErrorCode(err, errCodeKMSAccessDenied) || isAWSErrorCode(err, errCodeKMSNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list the tags of KMS key %s: %w", aws.ToString(entry.KeyArn), err)
+			}
+			tagged := false
+			for _, tag := range tags.Tags {
+				if aws.ToString(tag.TagKey) == key && aws.ToString(tag.TagValue) == value {
+					tagged = true
+					break
+				}
+			}
+			if !tagged {
+				continue
+			}
+			desc, err := c.kms.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: entry.KeyId})
+			if err != nil {
+				return nil, err
+			}
+			if desc.KeyMetadata.KeyState != kmstypes.KeyStatePendingDeletion {
+				arns = append(arns, aws.ToString(entry.KeyArn))
+			}
+		}
+	}
+	return arns, nil
+}
+
+// CallerIdentity returns the account ID and ARN of the credentials in use.
+func (c *awsClient) CallerIdentity(ctx context.Context) (string, string, error) {
+	if c.dryRun != nil {
+		out := c.plan("sts:GetCallerIdentity")
+		return out, out, nil
+	}
+	out, err := c.sts.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", "", err
+	}
+	return aws.ToString(out.Account), aws.ToString(out.Arn), nil
+}
+
+// PrintIdentity prints the account and identity AWS calls are made with and returns them, so a run
+// against the wrong account can be stopped before anything is changed.
+func (c *awsClient) PrintIdentity(ctx context.Context) (string, string, error) {
+	account, arn, err := c.CallerIdentity(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get the AWS caller identity: %w", err)
+	}
+	source := "the default credential chain"
+	if c.opts.Profile != "" {
+		source = "profile " + c.opts.Profile
+	}
+	if c.opts.AssumeRoleArn != "" {
+		source = fmt.Sprintf("role %s assumed with %s", c.opts.AssumeRoleArn, source)
+	}
+	fmt.Printf("AWS account %s, calling as %s (%s)\n", account, arn, source)
+	return account, arn, nil
+}
+
+// regionFromArn returns the region field of an ARN, "" for global resources.
+func regionFromArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[3]
+}
+
+// accountFromArn returns the account ID field of an ARN.
+func accountFromArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
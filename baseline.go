@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// backupBucketPrefix starts the name of every backup bucket.
+const backupBucketPrefix = "rosa-hcp-backup-oadp-"
+
+// bucketControl is one control of the hardening baseline of the backup buckets. apply brings a
+// bucket in line with the control and check returns what is wrong with a bucket, "" when nothing is.
+// Controls without apply are set by a later configure step.
+type bucketControl struct {
+	name  string
+	apply func(ctx context.Context, c *awsClient, bucket string) error
+	check func(ctx context.Context, c *awsClient, bucket string) (string, error)
+}
+
+// bucketBaseline is the hardening profile every backup bucket gets and audit checks.
+var bucketBaseline = []bucketControl{
+	{"public-access-block", applyPublicAccessBlock, checkPublicAccessBlock},
+	{"object-ownership", applyOwnershipEnforced, checkOwnershipEnforced},
+	{"versioning", applyVersioning, checkVersioning},
+	{"bucket-policy", applyBaselinePolicy, checkBaselinePolicy},
+	{"default-encryption", nil, checkDefaultEncryption},
+}
+
+// applyBucketBaseline applies every control of the baseline to a bucket.
+func applyBucketBaseline(ctx context.Context, c *awsClient, bucket string) error {
+	for _, control := range bucketBaseline {
+		if control.apply == nil {
+			continue
+		}
+		if err := control.apply(ctx, c, bucket); err != nil {
+			return fmt.Errorf("failed to apply %s to bucket %s: %w", control.name, bucket, err)
+		}
+		fmt.Printf("Applied %s to bucket '%s'.\n", control.name, bucket)
+	}
+	return nil
+}
+
+// auditBucket checks a bucket against every control of the baseline and returns the findings.
+func auditBucket(ctx context.Context, c *awsClient, bucket string) ([]string, error) {
+	var findings []string
+	for _, control := range bucketBaseline {
+		finding, err := control.check(ctx, c, bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s of bucket %s: %w", control.name, bucket, err)
+		}
+		if finding != "" && c.dryRun == nil {
+			findings = append(findings, fmt.Sprintf("%s: %s", control.name, finding))
+		}
+	}
+	return findings, nil
+}
+
+func applyPublicAccessBlock(ctx context.Context, c *awsClient, bucket string) error {
+	return c.PutPublicAccessBlock(ctx, bucket)
+}
+
+func checkPublicAccessBlock(ctx context.Context, c *awsClient, bucket string) (string, error) {
+	blocked, err := c.PublicAccessBlocked(ctx, bucket)
+	if err != nil || blocked {
+		return "", err
+	}
+	return "public access is not fully blocked", nil
+}
+
+func applyOwnershipEnforced(ctx context.Context, c *awsClient, bucket string) error {
+	return c.PutBucketOwnershipEnforced(ctx, bucket)
+}
+
+func checkOwnershipEnforced(ctx context.Context, c *awsClient, bucket string) (string, error) {
+	ownership, err := c.BucketOwnership(ctx, bucket)
+	if err != nil || ownership == "BucketOwnerEnforced" {
+		return "", err
+	}
+	return fmt.Sprintf("object ownership is %q, ACLs are not disabled", ownership), nil
+}
+
+func applyVersioning(ctx context.Context, c *awsClient, bucket string) error {
+	return c.EnableVersioning(ctx, bucket)
+}
+
+func checkVersioning(ctx context.Context, c *awsClient, bucket string) (string, error) {
+	status, err := c.BucketVersioning(ctx, bucket)
+	if err != nil || status == "Enabled" {
+		return "", err
+	}
+	return fmt.Sprintf("versioning is %q", status), nil
+}
+
+// applyBaselinePolicy adds the baseline statements to the bucket policy, keeping its other statements.
+func applyBaselinePolicy(ctx context.Context, c *awsClient, bucket string) error {
+	policy, err := c.BucketPolicy(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if c.dryRun != nil {
+		policy = ""
+	}
+	merged, err := mergePolicyStatements(policy, bucketBaselineStatements(bucket))
+	if err != nil {
+		return err
+	}
+	return c.PutBucketPolicy(ctx, bucket, merged)
+}
+
+func checkBaselinePolicy(ctx context.Context, c *awsClient, bucket string) (string, error) {
+	policy, err := c.BucketPolicy(ctx, bucket)
+	if err != nil || c.dryRun != nil {
+		return "", err
+	}
+	if policy == "" {
+		return "the bucket has no policy", nil
+	}
+	var doc policyDocument
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return fmt.Sprintf("the bucket policy cannot be parsed: %v", err), nil
+	}
+	switch {
+	case !hasDenyCondition(doc, "s3:GetObject", "Bool", "aws:SecureTransport"):
+		return "requests without TLS are not denied", nil
+	case !hasDenyCondition(doc, "s3:PutObject", "StringNotEquals", "s3:x-amz-server-side-encryption"):
+		return "uploads without SSE-KMS are not denied", nil
+	}
+	return "", nil
+}
+
+func checkDefaultEncryption(ctx context.Context, c *awsClient, bucket string) (string, error) {
+	algorithm, keyID, err := c.BucketEncryption(ctx, bucket)
+	if err != nil || (algorithm == "aws:kms" && keyID != "") {
+		return "", err
+	}
+	return fmt.Sprintf("default encryption is %q, not SSE-KMS with a customer key", algorithm), nil
+}
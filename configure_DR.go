@@ -1,16 +1,44 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha1"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
-	"os/exec"
 	"strings"
+	"time"
 	"unicode"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+// veleroNamespace is the namespace OADP runs Velero in on the management cluster.
+const veleroNamespace = "openshift-adp"
+
+// s3FullAccessPolicyArn is the managed policy earlier versions attached to the backup role.
+// It grants access to every bucket of the account, harden replaces it with bucketAccessPolicyName.
+const s3FullAccessPolicyArn = "arn:aws:iam::aws:policy/AmazonS3FullAccess"
+
+// bucketAccessPolicyName is the inline policy of the backup role that grants access to its bucket.
+const bucketAccessPolicyName = "VeleroBackupBucketAccess"
+
+// backupPrefix is the prefix the BSL keeps the backups under in the bucket.
+const backupPrefix = "backup-objects"
+
+// scheduleTTL is how long Velero keeps the backups of the hourly schedule.
+const scheduleTTL = 24 * time.Hour
+
+// objectLockDays returns the default retention of an immutable bucket: the schedule TTL rounded
+// up to whole days, so a backup stays locked for at least as long as Velero keeps it.
+func objectLockDays() int32 {
+	return int32((scheduleTTL + 24*time.Hour - 1) / (24 * time.Hour))
+}
+
 // BackupConfig holds all the variables needed to populate the backup template.
 type BackupConfig struct {
 	SecretData  string
@@ -18,30 +46,23 @@ type BackupConfig struct {
 	ClusterName string
 	ClusterEnv  string
 	BucketName  string
+	Region      string
+	KMSKeyID    string
 }
 
-// runCommand executes a shell command and returns its stdout and stderr.
-// It also prints the command being executed for clarity.
+// runCommand executes a command through the package level executor and returns its stdout and stderr.
 func runCommand(name string, arg ...string) (string, string, error) {
-	cmd := exec.Command(name, arg...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	// Print the command being executed
-	fmt.Printf("Executing command: %s %s\n", name, strings.Join(arg, " "))
-
-	err := cmd.Run()
+	stdout, stderr, err := executor.Run(name, arg...)
 	if err != nil {
 		// Return a detailed error message including stderr
-		return stdout.String(), stderr.String(), fmt.Errorf("command failed: %w, stderr: %s", err, stderr.String())
+		return stdout, stderr, fmt.Errorf("command failed: %w, stderr: %s", err, stderr)
 	}
-	return stdout.String(), stderr.String(), nil
+	return stdout, stderr, nil
 }
 
 // setupCluster performs the sequence of steps to set up and configure a cluster.
 // It takes the cluster ID, name, and environment as input.
-// Assuming that there is a hive cluster and the kubeconfigs (Management and Service Clusters) are already saved on users local.
+// The Management and Service Cluster are reached through the kubeconfigs given with --mc-kubeconfig and --sc-kubeconfig.
 func setupCluster(clusterID, clusterName, clusterEnv string) error {
 	fmt.Println("--- Cluster Setup Started ---")
 
@@ -56,23 +77,23 @@ func setupCluster(clusterID, clusterName, clusterEnv string) error {
 	clusterNameerr := os.Setenv("cluster_name", clusterName)
 	if clusterNameerr != nil {
 		// Log the error and stderr if the command fails
-		return fmt.Errorf("failed to create cluster name variable: %w, stderr: %s", clusterNameerr)
+		return fmt.Errorf("failed to create cluster name variable: %w", clusterNameerr)
 	}
-	fmt.Println("Cluster Name environment variable created: %s\n", os.Getenv("cluster_name"))
+	fmt.Printf("Cluster Name environment variable created: %s\n", os.Getenv("cluster_name"))
 
 	clusterIDerr := os.Setenv("cluster_id", clusterID)
 	if clusterIDerr != nil {
 		// Log the error and stderr if the command fails
-		return fmt.Errorf("failed to create cluster name variable: %w, stderr: %s", clusterIDerr)
+		return fmt.Errorf("failed to create cluster id variable: %w", clusterIDerr)
 	}
-	fmt.Println("Cluster Id environment variable created: %s\n", os.Getenv("cluster_id"))
+	fmt.Printf("Cluster Id environment variable created: %s\n", os.Getenv("cluster_id"))
 
 	clusterEnverr := os.Setenv("cluster_env", clusterEnv)
 	if clusterEnverr != nil {
 		// Log the error and stderr if the command fails
-		return fmt.Errorf("failed to create cluster Env variable: %w, stderr: %s", clusterEnverr)
+		return fmt.Errorf("failed to create cluster Env variable: %w", clusterEnverr)
 	}
-	fmt.Println("Cluster Env environment variable created: %s\n", os.Getenv("cluster_env"))
+	fmt.Printf("Cluster Env environment variable created: %s\n", os.Getenv("cluster_env"))
 
 	// Step 2: Check cluster health using 'rosa describe cluster'
 	fmt.Printf("\nStep 2: Checking cluster health for '%s'...\n", clusterName)
@@ -150,10 +171,23 @@ func setupCluster(clusterID, clusterName, clusterEnv string) error {
 }
 
 // createS3Bucket performs the steps to create an AWS S3 bucket.
-// It sets the AWS_PROFILE and generates a unique bucket name.
-func createS3Bucket(awsProfile, region string) (string, error) {
+// It generates a unique bucket name and applies the hardening baseline to the bucket.
+// With a lockMode the bucket is created with Object Lock and a default retention of lockDays.
+// An existing bucketName skips the creation and only hardens that bucket again. The bucket name is
+// returned even when hardening fails, so that a resumed run reuses the bucket instead of creating
+// a second one.
+func createS3Bucket(ctx context.Context, c *awsClient, bucketName, lockMode string, lockDays int32) (string, error) {
 	fmt.Println("\n--- AWS S3 Bucket Creation Started ---")
 
+	if bucketName != "" {
+		fmt.Printf("Step 1: Reusing S3 bucket '%s' created by an earlier run, hardening it again...\n", bucketName)
+		if err := hardenBucket(ctx, c, bucketName, lockMode, lockDays); err != nil {
+			return bucketName, err
+		}
+		fmt.Println("--- AWS S3 Bucket Creation Completed ---")
+		return bucketName, nil
+	}
+
 	// Step 1: Generate a unique bucket name using uuidgen
 	fmt.Println("Step 1: Generating unique bucket name...")
 	uuidStdout, uuidStderr, err := runCommand("uuidgen")
@@ -163,87 +197,152 @@ func createS3Bucket(awsProfile, region string) (string, error) {
 	// Clean up the UUID: remove hyphens and convert to lowercase
 	generatedUUID := strings.TrimSpace(uuidStdout)
 	cleanedUUID := strings.ReplaceAll(generatedUUID, "-", "")
-	bucketName := fmt.Sprintf("rosa-hcp-backup-oadp-%s", strings.ToLower(cleanedUUID))
+	bucketName = backupBucketPrefix + strings.ToLower(cleanedUUID)
 	fmt.Printf("Generated bucket name: %s\n", bucketName)
 
-	// Step 2: Set AWS_PROFILE and create the S3 bucket
-	fmt.Printf("Step 2: Creating S3 bucket '%s' in region '%s' with AWS_PROFILE='%s'...\n", bucketName, region, awsProfile)
-
-	cmd_awsProfile := exec.Command("export", "AWS_PROFILE=", awsProfile)
-	fmt.Println(cmd_awsProfile)
+	// Step 2: Create the S3 bucket
+	fmt.Printf("Step 2: Creating S3 bucket '%s' in region '%s'...\n", bucketName, c.cfg.Region)
+	if created, err := createHardenedBucket(ctx, c, bucketName, lockMode, lockDays); err != nil {
+		if created {
+			return bucketName, err
+		}
+		return "", err
+	}
 
-	// Execute the aws s3api create-bucket command
-	s3Stdout, s3Stderr, err := runCommand(
-		"aws", "s3api", "create-bucket",
-		"--bucket", bucketName,
-		"--region", region,
-		"--create-bucket-configuration", fmt.Sprintf("LocationConstraint=%s", region))
+	fmt.Println("--- AWS S3 Bucket Creation Completed ---")
+	return bucketName, nil
+}
 
-	if err != nil {
-		return "", fmt.Errorf("failed to create S3 bucket: %w, stderr: %s", err, s3Stderr)
+// createHardenedBucket creates a bucket in the client's region, optionally with Object Lock, and
+// hardens it. It reports whether the bucket was created, also when hardening it failed.
+func createHardenedBucket(ctx context.Context, c *awsClient, bucketName, lockMode string, lockDays int32) (bool, error) {
+	if err := c.CreateBucket(ctx, bucketName, lockMode != ""); err != nil {
+		return false, fmt.Errorf("failed to create S3 bucket: %w", err)
 	}
-	fmt.Println("S3 Bucket Creation Output:\n", s3Stdout)
 	fmt.Printf("S3 bucket '%s' created successfully.\n", bucketName)
+	rollback.register(fmt.Sprintf("delete S3 bucket %s", bucketName), undoDeleteBucket(ctx, c, bucketName))
+	return true, hardenBucket(ctx, c, bucketName, lockMode, lockDays)
+}
 
-	fmt.Println("--- AWS S3 Bucket Creation Completed ---")
-	return bucketName, nil
+// hardenBucket sets the default retention of lockDays on a bucket created with Object Lock and
+// applies the hardening baseline to it.
+func hardenBucket(ctx context.Context, c *awsClient, bucketName, lockMode string, lockDays int32) error {
+	if lockMode != "" {
+		fmt.Printf("Setting the default retention of bucket '%s' to %s mode for %d days...\n", bucketName, lockMode, lockDays)
+		if err := c.PutObjectLockConfiguration(ctx, bucketName, lockMode, lockDays); err != nil {
+			return fmt.Errorf("failed to set the default retention of bucket %s: %w", bucketName, err)
+		}
+	}
+
+	fmt.Printf("Applying the hardening baseline to bucket '%s'...\n", bucketName)
+	return applyBucketBaseline(ctx, c, bucketName)
 }
 
-// createOIDCConfig retrieves the OIDC endpoint URL and extracts the OIDC ID.
-func createOIDCConfig(mcName, region, clusterId string) (string, string, string, error) {
+// createOIDCConfig retrieves the OIDC endpoint URL of the management cluster from OCM, extracts the OIDC ID
+// and finds the IAM OIDC provider of that issuer. With createProvider a missing provider is created.
+func createOIDCConfig(ctx context.Context, ocm *ocmClient, c *awsClient, mcName, region, clusterId string, createProvider bool) (mcOIDCUrl, mcOIDC, mcOIDCArn string, created bool, err error) {
 	fmt.Println("\n--- OIDC Configuration Started ---")
 
+	mcOIDCUrl, mcOIDC, err = managementClusterOIDC(ctx, ocm, mcName, region)
+	if err != nil {
+		return "", "", "", false, err
+	}
+
+	// Step 4: Find the IAM OIDC provider of the issuer
+	mcOIDCArn, err = c.FindOIDCProvider(ctx, mcOIDC)
+	if err != nil {
+		return "", "", "", false, fmt.Errorf("failed to list IAM OIDC providers: %w", err)
+	}
+	if mcOIDCArn == "" {
+		if !createProvider {
+			return "", "", "", false, fmt.Errorf("no IAM OIDC provider found for %s, rerun with --create-oidc-provider to create it", mcOIDC)
+		}
+		fmt.Printf("No IAM OIDC provider found for '%s', creating it...\n", mcOIDC)
+		thumbprint, err := oidcThumbprint(ctx, mcOIDCUrl)
+		if err != nil {
+			return "", "", "", false, fmt.Errorf("failed to get the thumbprint of %s: %w", mcOIDCUrl, err)
+		}
+		var thumbprints []string
+		if thumbprint != "" {
+			thumbprints = []string{thumbprint}
+		}
+		mcOIDCArn, err = c.CreateOIDCProvider(ctx, mcOIDCUrl, []string{"openshift", "sts.amazonaws.com"}, thumbprints)
+		if err != nil {
+			return "", "", "", false, fmt.Errorf("failed to create IAM OIDC provider for %s: %w", mcOIDC, err)
+		}
+		rollback.register(fmt.Sprintf("delete IAM OIDC provider %s", mcOIDCArn), undoDeleteOIDCProvider(ctx, c, mcOIDCArn))
+		created = true
+	}
+	fmt.Printf("mc_oidc_arn: %s\n", mcOIDCArn)
+	fmt.Println("--- OIDC Configuration Completed ---")
+	return mcOIDCUrl, mcOIDC, mcOIDCArn, created, nil
+}
+
+// managementClusterOIDC looks up the OIDC endpoint URL of a management cluster in OCM and returns
+// it with the OIDC ID, the URL without its scheme.
+func managementClusterOIDC(ctx context.Context, ocm *ocmClient, mcName, region string) (mcOIDCUrl, mcOIDC string, err error) {
 	// Step 1: Get the management cluster reference href
 	fmt.Printf("Step 1: Getting OIDC endpoint URL for management cluster '%s' in region '%s'...\n", mcName, region)
-	// The ocm get command with jq needs to be executed via bash -c to handle pipes
-	ocmGetHrefCmd := fmt.Sprintf(`ocm get /api/osd_fleet_mgmt/v1/management_clusters -p search="region='%s' and name='%s'" | jq -r '.items[].cluster_management_reference.href'`, region, mcName)
-	hrefStdout, hrefStderr, err := runCommand("bash", "-c", ocmGetHrefCmd)
+	mc, err := ocm.FindManagementCluster(ctx, mcName, region)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to get management cluster href: %w, stderr: %s", err, hrefStderr)
+		return "", "", fmt.Errorf("failed to get management cluster href: %w", err)
 	}
-	mcHref := strings.TrimSpace(hrefStdout)
+	mcHref := mc.ClusterManagementReference.Href
 	if mcHref == "" {
-		return "", "", "", fmt.Errorf("management cluster href not found for %s in %s", mcName, region)
+		return "", "", fmt.Errorf("management cluster href not found for %s in %s", mcName, region)
 	}
 	fmt.Printf("Management Cluster Href: %s\n", mcHref)
 
 	// Step 2: Get the OIDC endpoint URL using the href
-	ocmGetOIDCUrlCmd := fmt.Sprintf(`ocm get %s | jq -r '.aws.sts.oidc_endpoint_url'`, mcHref)
-	oidcUrlStdout, oidcUrlStderr, err := runCommand("bash", "-c", ocmGetOIDCUrlCmd)
+	cluster, err := ocm.GetCluster(ctx, mcHref)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to get OIDC endpoint URL: %w, stderr: %s", err, oidcUrlStderr)
+		return "", "", fmt.Errorf("failed to get OIDC endpoint URL: %w", err)
 	}
-	mcOIDCUrl := strings.TrimSpace(oidcUrlStdout)
+	mcOIDCUrl = cluster.AWS.STS.OIDCEndpointURL
 	if mcOIDCUrl == "" {
-		return "", "", "", fmt.Errorf("OIDC endpoint URL not found")
+		return "", "", fmt.Errorf("OIDC endpoint URL not found")
 	}
 	fmt.Printf("mc_oidc_url: %s\n", mcOIDCUrl)
 
 	// Step 3: Extract the OIDC ID by removing "https://"
-	mcOIDCCmd := fmt.Sprintf(`echo %s | sed -r 's/https:\/\///'`, mcOIDCUrl)
-	oidcStdout, oidcStderr, err := runCommand("bash", "-c", mcOIDCCmd)
-	if err != nil {
-		return "", "", "", fmt.Errorf("failed to extract OIDC ID: %w, stderr: %s", err, oidcStderr)
-	}
-	mcOIDC := strings.TrimSpace(oidcStdout)
+	mcOIDC = strings.TrimPrefix(mcOIDCUrl, "https://")
 	if mcOIDC == "" {
-		return "", "", "", fmt.Errorf("OIDC ID could not be extracted")
+		return "", "", fmt.Errorf("OIDC ID could not be extracted")
 	}
 	fmt.Printf("mc_oidc: %s\n", mcOIDC)
+	return mcOIDCUrl, mcOIDC, nil
+}
 
-	// Step 4: Get OIDC ARN
-	mcOIDCArnCmd := fmt.Sprintf("aws iam list-open-id-connect-providers --output json | jq -r '.OpenIDConnectProviderList[].Arn' | grep 2juqamhdcrm6o3f7i5l309lgnu72bmb0")
-	oidcArnStdout, oidcArnStderr, err := runCommand("bash", "-c", mcOIDCArnCmd)
+// oidcThumbprint fetches the discovery document of an OIDC issuer and returns the SHA-1
+// thumbprint of the top certificate of the chain it is served with, as IAM expects it.
+// A replayed response carries no certificates, the thumbprint is then left to IAM.
+func oidcThumbprint(ctx context.Context, issuerURL string) (string, error) {
+	discoveryURL := strings.TrimRight(issuerURL, "/") + "/.well-known/openid-configuration"
+	if dryRun, ok := executor.(*dryRunExecutor); ok {
+		return dryRun.plan("GET", discoveryURL), nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to extract OIDC ID: %w, stderr: %s", err, oidcArnStderr)
+		return "", err
 	}
-	fmt.Printf("mc_oidc_arn: %s\n", oidcArnStdout)
-	fmt.Println("--- OIDC Configuration Completed ---")
-	return mcOIDCUrl, mcOIDC, oidcArnStdout, nil
+	resp, err := (&http.Client{Transport: httpTransport}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status %d", discoveryURL, resp.StatusCode)
+	}
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return "", nil
+	}
+	chain := resp.TLS.PeerCertificates
+	sum := sha1.Sum(chain[len(chain)-1].Raw)
+	return hex.EncodeToString(sum[:]), nil
 }
 
-// createIAMRole creates an IAM role and attaches a policy.
-func createIAMRole(awsProfile, mcName, clusterID, mcOIDCUrl, mcOIDC, mcOIDCArn string) (string, error) {
+// createIAMRole creates an IAM role and grants it access to the backup prefix of bucketName.
+func createIAMRole(ctx context.Context, c *awsClient, mcName, clusterID, bucketName, mcOIDCUrl, mcOIDC, mcOIDCArn string) (string, error) {
 
 	// Step 1: Define role name
 	roleName := fmt.Sprintf("rosa-hcp-bkp-%s-%s", mcName, clusterID)
@@ -255,84 +354,99 @@ func createIAMRole(awsProfile, mcName, clusterID, mcOIDCUrl, mcOIDC, mcOIDCArn s
 	// Construct the assume role policy document
 	assumeRolePolicyDoc := fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"%s"},"Action":["sts:AssumeRoleWithWebIdentity"],"Condition":{"StringEquals":{"%s:sub":"system:serviceaccount:openshift-adp:velero"}}}]}`, strings.TrimRightFunc(mcOIDCArn, unicode.IsSpace), mcOIDC)
 
-	createRoleArgs := []string{
-		"iam", "create-role",
-		"--role-name", roleName,
-		"--assume-role-policy-document", assumeRolePolicyDoc,
-		"--description", fmt.Sprintf("\"backup-role for cluster %s\"", clusterID),
-	}
-	createRoleStdout, createRoleStderr, err := runCommand("aws", createRoleArgs...)
+	_, err := c.CreateRole(ctx, roleName, assumeRolePolicyDoc, fmt.Sprintf("backup-role for cluster %s", clusterID))
 	if err != nil {
 		// Check if the error is due to the role already existing
-		if strings.Contains(createRoleStderr, "EntityAlreadyExists") {
+		if isAWSErrorCode(err, errCodeEntityAlreadyExists) {
 			fmt.Printf("Warning: IAM role '%s' already exists. Skipping creation.\n", roleName)
 		} else {
-			return "", fmt.Errorf("failed to create IAM role: %w, stderr: %s", err, createRoleStderr)
+			return "", fmt.Errorf("failed to create IAM role: %w", err)
 		}
 	} else {
-		fmt.Println("IAM Role Creation Output:\n", createRoleStdout)
 		fmt.Printf("IAM role '%s' created successfully.\n", roleName)
+		rollback.register(fmt.Sprintf("delete IAM role %s", roleName), undoDeleteRole(ctx, c, roleName))
 	}
 
 	// Step 5: Get Role ARN
 	fmt.Printf("Step 5: Getting ARN for role '%s'...\n", roleName)
-	getRoleCmd := fmt.Sprintf(`aws iam get-role --role-name %s | awk 'NR==1 {print $2}'`, roleName)
-	roleArnStdout, roleArnStderr, err := runCommand("bash", "-c", getRoleCmd)
+	roleArn, err := c.GetRoleArn(ctx, roleName)
 	if err != nil {
-		return "", fmt.Errorf("failed to get role ARN: %w, stderr: %s", err, roleArnStderr)
-	}
-	roleArn := strings.TrimSpace(roleArnStdout)
-	if roleArn == "" {
-		return "", fmt.Errorf("role ARN could not be retrieved for role '%s'", roleName)
+		return "", fmt.Errorf("failed to get role ARN: %w", err)
 	}
 	fmt.Printf("role_arn: %s\n", roleArn)
 
-	// Step 6: Attach AmazonS3FullAccess policy
-	fmt.Printf("Step 6: Attaching AmazonS3FullAccess policy to role '%s'...\n", roleName)
-	attachPolicyStdout, attachPolicyStderr, err := runCommand(
-		"aws", "iam", "attach-role-policy",
-		"--policy-arn", "arn:aws:iam::aws:policy/AmazonS3FullAccess",
-		"--role-name", roleName)
-	if err != nil {
-		return "", fmt.Errorf("failed to attach AmazonS3FullAccess policy: %w, stderr: %s", err, attachPolicyStderr)
+	// Step 6: Grant the role access to the backup bucket
+	fmt.Printf("Step 6: Granting role '%s' access to bucket '%s'...\n", roleName, bucketName)
+	if err := putBucketAccessPolicy(ctx, c, roleName, bucketName); err != nil {
+		return "", err
 	}
-	fmt.Println("Attach Policy Output:\n", attachPolicyStdout)
-	fmt.Printf("AmazonS3FullAccess policy attached to role '%s'.\n", roleName)
+	rollback.register(fmt.Sprintf("delete inline policy %s of role %s", bucketAccessPolicyName, roleName), undoDeleteRolePolicy(ctx, c, roleName, bucketAccessPolicyName))
 
 	// Step 7: List attached role policies for verification
 	fmt.Printf("Step 7: Listing attached policies for role '%s'...\n", roleName)
-	listPoliciesStdout, listPoliciesStderr, err := runCommand(
-		"aws", "iam", "list-attached-role-policies",
-		"--role-name", roleName)
+	attachedPolicies, err := c.ListAttachedRolePolicies(ctx, roleName)
 	if err != nil {
-		return "", fmt.Errorf("failed to list attached role policies: %w, stderr: %s", err, listPoliciesStderr)
+		return "", fmt.Errorf("failed to list attached role policies: %w", err)
 	}
-	fmt.Println("List Policies Output:\n", listPoliciesStdout)
+	fmt.Println("Attached Policies:", attachedPolicies)
 
 	fmt.Println("--- IAM Role Creation Completed ---")
 	return roleArn, nil
 }
 
+// putBucketAccessPolicy sets the inline policy that limits the role to the backup prefix of bucketName.
+func putBucketAccessPolicy(ctx context.Context, c *awsClient, roleName, bucketName string) error {
+	document, err := buildBucketAccessPolicy(bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to build the bucket access policy: %w", err)
+	}
+	if err := c.PutRolePolicy(ctx, roleName, bucketAccessPolicyName, document); err != nil {
+		return fmt.Errorf("failed to put inline policy %s on role %s: %w", bucketAccessPolicyName, roleName, err)
+	}
+	fmt.Printf("Inline policy '%s' grants role '%s' access to s3://%s/%s/.\n", bucketAccessPolicyName, roleName, bucketName, backupPrefix)
+	return nil
+}
+
+// clusterTagKey is the tag that names the cluster on its KMS keys and IAM policies, so teardown
+// can find them without a ledger.
+const clusterTagKey = "cluster"
+
+// kmsPolicyNamePrefix starts the name of the IAM policy that lets the backup role use its key.
+const kmsPolicyNamePrefix = "AllowSSEKMSBackupKey-"
+
+// clusterTags returns the tags of the KMS keys and IAM policies created for a cluster.
+func clusterTags(clusterID, clusterEnv string) map[string]string {
+	return map[string]string{
+		"Owner":       clusterEnv,
+		clusterTagKey: clusterID,
+	}
+}
+
 // createKMSKeyAndPolicy creates an AWS KMS key, an associated IAM policy,
 // attaches a key policy to the KMS key, and attaches the IAM policy to the role.
-func createKMSKeyAndPolicy(awsProfile, clusterID, clusterEnv, awsRegion, roleArn string) (string, string, error) {
+// The key is created with vault, in account, and the IAM policy with c, in the account of the role.
+// It returns the KMS key ARN and the name and ARN of the IAM policy.
+// An existing kmsArn skips the key creation, and the key ARN is returned even when a later step
+// fails so that a resumed run reuses the key instead of creating a second one.
+func createKMSKeyAndPolicy(ctx context.Context, c, vault *awsClient, clusterID, clusterEnv, awsRegion, account, roleArn, kmsArn string, adminPatterns []string) (string, string, string, error) {
 	fmt.Println("\n--- KMS Key and Policy Creation Started ---")
 
 	// Step 1: Create KMS Key
-	fmt.Printf("Step 1: Creating KMS key for cluster '%s'...\n", clusterID)
-	createKeyCmd := fmt.Sprintf(`aws kms create-key --description "SSE-KMS backup key: %s" --key-usage ENCRYPT_DECRYPT --key-spec SYMMETRIC_DEFAULT --tags "TagKey=Owner,TagValue=%s" "TagKey=cluster,TagValue=%s" --region %s --query 'KeyMetadata.Arn' --output text`, clusterID, clusterEnv, clusterID, awsRegion)
-	kmsArnStdout, kmsArnStderr, err := runCommand("bash", "-c", createKeyCmd)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to create KMS key: %w, stderr: %s", err, kmsArnStderr)
-	}
-	kmsArn := strings.TrimSpace(kmsArnStdout)
-	if kmsArn == "" {
-		return "", "", fmt.Errorf("KMS ARN could not be retrieved")
+	if kmsArn != "" {
+		fmt.Printf("Step 1: Reusing KMS key '%s' for cluster '%s'.\n", kmsArn, clusterID)
+	} else {
+		fmt.Printf("Step 1: Creating KMS key for cluster '%s'...\n", clusterID)
+		var err error
+		kmsArn, err = vault.CreateKey(ctx, fmt.Sprintf("SSE-KMS backup key: %s", clusterID), clusterTags(clusterID, clusterEnv))
+		if err != nil {
+			return "", "", "", fmt.Errorf("failed to create KMS key: %w", err)
+		}
+		rollback.register(fmt.Sprintf("schedule deletion of KMS key %s", kmsArn), undoScheduleKeyDeletion(ctx, vault, kmsArn))
 	}
 	fmt.Printf("kms_arn: %s\n", kmsArn)
 
 	// Step 2: Define KMS IAM Policy Name
-	kmsIAMPolicyName := fmt.Sprintf("AllowSSEKMSBackupKey-%s", clusterID)
+	kmsIAMPolicyName := kmsPolicyNamePrefix + clusterID
 	fmt.Printf("kms_iam_policy_name: %s\n", kmsIAMPolicyName)
 
 	// Step 3: Create IAM Policy for KMS Key
@@ -353,123 +467,70 @@ func createKMSKeyAndPolicy(awsProfile, clusterID, clusterEnv, awsRegion, roleArn
 		]
 	}`, kmsArn)
 
-	createPolicyArgs := []string{
-		"iam", "create-policy",
-		"--policy-name", kmsIAMPolicyName,
-		"--policy-document", kmsIAMPolicyDoc,
-	}
-	createPolicyStdout, createPolicyStderr, err := runCommand("aws", createPolicyArgs...)
+	policyArn, err := c.CreatePolicy(ctx, kmsIAMPolicyName, kmsIAMPolicyDoc, clusterTags(clusterID, clusterEnv))
 	if err != nil {
-		if strings.Contains(createPolicyStderr, "EntityAlreadyExists") {
+		if isAWSErrorCode(err, errCodeEntityAlreadyExists) {
 			fmt.Printf("Warning: IAM policy '%s' already exists. Skipping creation.\n", kmsIAMPolicyName)
+			// Customer managed policies live in the role's account.
+			policyArn = fmt.Sprintf("arn:aws:iam::%s:policy/%s", accountFromArn(roleArn), kmsIAMPolicyName)
 		} else {
-			return "", "", fmt.Errorf("failed to create IAM policy: %w, stderr: %s", err, createPolicyStderr)
+			return kmsArn, "", "", fmt.Errorf("failed to create IAM policy: %w", err)
 		}
 	} else {
-		fmt.Println("Create IAM Policy Output:\n", createPolicyStdout)
 		fmt.Printf("IAM policy '%s' created successfully.\n", kmsIAMPolicyName)
+		rollback.register(fmt.Sprintf("delete IAM policy %s", kmsIAMPolicyName), undoDeletePolicy(ctx, c, policyArn))
 	}
+	fmt.Printf("policy_arn: %s\n", policyArn)
 
 	// Step 4: Put Key Policy on KMS Key
 	fmt.Printf("Step 4: Putting key policy on KMS key '%s'...\n", kmsArn)
-	// Note: You need to replace '765374464689' with your actual AWS account ID for the 'emathias' user.
-	// This is hardcoded in the original prompt, but ideally should be dynamic or a configurable parameter.
-	// For this example, I'm using the hardcoded value.
-	kmsKeyPolicyDoc := fmt.Sprintf(`{
-		"Version": "2012-10-17",
-		"Statement": [
-			{
-				"Sid": "AllowClusterRoleAccess",
-				"Effect": "Allow",
-				"Principal": {
-					"AWS": "%s"
-				},
-				"Action": [
-					"kms:Encrypt",
-					"kms:Decrypt",
-					"kms:GenerateDataKey",
-					"kms:DescribeKey"
-				],
-				"Resource": "*"
-			},
-			{
-				"Sid": "AllowEmathiasFullAccess",
-				"Effect": "Allow",
-				"Principal": {
-					"AWS": "*"
-				},
-				"Action": "kms:*",
-				"Resource": "*",
-				"Condition": {
-					"StringEquals": {
-						"aws:PrincipalArn": "arn:aws:iam::765374464689:user/emathias"
-					}
-				}
-			}
-		]
-	}`, roleArn)
-
-	putKeyPolicyArgs := []string{
-		"kms", "put-key-policy",
-		"--key-id", kmsArn,
-		"--policy-name", "default",
-		"--region", awsRegion,
-		"--policy", kmsKeyPolicyDoc,
-	}
-	putKeyPolicyStdout, putKeyPolicyStderr, err := runCommand("aws", putKeyPolicyArgs...)
+	kmsKeyPolicyDoc, err := buildKMSKeyPolicy(account, roleArn, adminPatterns)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to put key policy on KMS key: %w, stderr: %s", err, putKeyPolicyStderr)
+		return kmsArn, "", "", fmt.Errorf("failed to build key policy: %w", err)
 	}
-	fmt.Println("Put Key Policy Output:\n", putKeyPolicyStdout)
-	fmt.Printf("Key policy attached to KMS key '%s'.\n", kmsArn)
+	fmt.Printf("Key administrators: %s\n", strings.Join(adminPatterns, ", "))
 
-	// Step 5: Get Policy ARN for the new IAM policy
-	fmt.Printf("Step 5: Getting ARN for IAM policy '%s'...\n", kmsIAMPolicyName)
-	getPolicyArnCmd := fmt.Sprintf(`aws iam list-policies --query "Policies[?PolicyName=='%s'].Arn" --output text`, kmsIAMPolicyName)
-	policyArnStdout, policyArnStderr, err := runCommand("bash", "-c", getPolicyArnCmd)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to get policy ARN for '%s': %w, stderr: %s", kmsIAMPolicyName, err, policyArnStderr)
-	}
-	policyArn := strings.TrimSpace(policyArnStdout)
-	if policyArn == "" {
-		return "", "", fmt.Errorf("policy ARN for '%s' could not be retrieved", kmsIAMPolicyName)
+	if err := vault.PutKeyPolicy(ctx, kmsArn, kmsKeyPolicyDoc); err != nil {
+		return kmsArn, "", "", fmt.Errorf("failed to put key policy on KMS key: %w", err)
 	}
-	fmt.Printf("policy_arn: %s\n", policyArn)
+	fmt.Printf("Key policy attached to KMS key '%s'.\n", kmsArn)
 
 	// Step 6: Attach the new IAM policy to the role
-	fmt.Printf("Step 6: Attaching IAM policy '%s' to role '%s'...\n", kmsIAMPolicyName, strings.Split(roleArn, "/")[1]) // Extract role name from ARN
-	attachRolePolicyStdout, attachRolePolicyStderr, err := runCommand(
-		"aws", "iam", "attach-role-policy",
-		"--role-name", strings.Split(roleArn, "/")[1], // Extract role name from ARN
-		"--policy-arn", policyArn)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to attach IAM policy '%s' to role '%s': %w, stderr: %s", kmsIAMPolicyName, strings.Split(roleArn, "/")[1], err, attachRolePolicyStderr)
+	roleName := roleNameFromArn(roleArn)
+	fmt.Printf("Step 6: Attaching IAM policy '%s' to role '%s'...\n", kmsIAMPolicyName, roleName)
+	if err := c.AttachRolePolicy(ctx, roleName, policyArn); err != nil {
+		return kmsArn, "", "", fmt.Errorf("failed to attach IAM policy '%s' to role '%s': %w", kmsIAMPolicyName, roleName, err)
 	}
-	fmt.Println("Attach Role Policy Output:\n", attachRolePolicyStdout)
-	fmt.Printf("IAM policy '%s' attached to role '%s'.\n", kmsIAMPolicyName, strings.Split(roleArn, "/")[1])
+	fmt.Printf("IAM policy '%s' attached to role '%s'.\n", kmsIAMPolicyName, roleName)
+	rollback.register(fmt.Sprintf("detach %s from role %s", kmsIAMPolicyName, roleName), undoDetachRolePolicy(ctx, c, roleName, policyArn))
 
 	// Step 7: List attached role policies for verification
-	fmt.Printf("Step 7: Listing attached policies for role '%s'...\n", strings.Split(roleArn, "/")[1])
-	listPoliciesStdout, listPoliciesStderr, err := runCommand(
-		"aws", "iam", "list-attached-role-policies",
-		"--role-name", strings.Split(roleArn, "/")[1])
+	fmt.Printf("Step 7: Listing attached policies for role '%s'...\n", roleName)
+	attachedPolicies, err := c.ListAttachedRolePolicies(ctx, roleName)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to list attached role policies: %w, stderr: %s", err, listPoliciesStderr)
+		return kmsArn, "", "", fmt.Errorf("failed to list attached role policies: %w", err)
 	}
-	fmt.Println("List Policies Output:\n", listPoliciesStdout)
+	fmt.Println("Attached Policies:", attachedPolicies)
 
 	fmt.Println("--- KMS Key and Policy Creation Completed ---")
-	return kmsArn, kmsIAMPolicyName, nil
+	return kmsArn, kmsIAMPolicyName, policyArn, nil
 }
 
-func GenerateAWSRoleSecret(roleArn, filename string) (string, error) {
+// roleNameFromArn returns the role name at the end of an IAM role ARN.
+func roleNameFromArn(roleArn string) string {
+	return roleArn[strings.LastIndex(roleArn, "/")+1:]
+}
+
+// GenerateAWSRoleSecret renders the AWS credentials file Velero uses to assume the backup role
+// in region and returns it base64 encoded.
+func GenerateAWSRoleSecret(roleArn, region, filename string) (string, error) {
 	// Define the content template for the aws_role.txt file.
 	// Using a placeholder like "${ROLE_ARN}" is a common practice for substitution.
 	fileContentTemplate := fmt.Sprintf(`[default]
 role_arn = %s
 web_identity_token_file = /var/run/secrets/openshift/serviceaccount/token
-region=us-west-2
-`, roleArn)
+region=%s
+`, roleArn, region)
 
 	// Substitute the placeholder with the actual role ARN.
 	substitutedContent := strings.Replace(fileContentTemplate, "${ROLE_ARN}", roleArn, 1)
@@ -483,25 +544,19 @@ region=us-west-2
 	// }
 	// fmt.Printf("secret data standard output: %s\n", secretStdOut)
 
-	err := os.Setenv("secret_data", encodedData)
-	if err != nil {
-		fmt.Printf("Secret data error: %s\n", err)
-	}
-	secretData := os.Getenv("secret_data")
-	fmt.Printf("Secret Data is %s\n", secretData)
-	return secretData, nil
+	// The credentials are never printed, the output ends up in --record transcripts.
+	return encodedData, nil
 }
 
-// GenerateBackupResources takes a BackupConfig and populates the backup YAML template.
-// This function mimics the behavior of:
-// envsubst < backup_template.yaml > backup_resources.yaml
-func CreateBackupResources(config BackupConfig) (string, error) {
+// CreateBackupResources takes a BackupConfig, populates the backup YAML template and applies
+// the resulting Secret, BackupStorageLocation and Schedule to the cluster.
+func CreateBackupResources(ctx context.Context, kube *kubeClient, config BackupConfig) (string, error) {
 	// The content of backup_template.yaml is defined here as a multi-line string.
 	// Note: In Go, we use placeholders like ${VAR} for clarity, which we'll replace.
 	// The shell's $VAR syntax is handled by the replacer.
 	template := `apiVersion: v1
 data:
-credentials: ${SECRET_DATA}
+  credentials: ${SECRET_DATA}
 kind: Secret
 metadata:
   name: ${CLUSTER_ID}-backup-role
@@ -517,13 +572,14 @@ spec:
   provider: aws
   objectStorage:
     bucket: ${BUCKET_NAME}
-    prefix: backup-objects
+    prefix: ${PREFIX}
   credential:
     name: ${CLUSTER_ID}-backup-role
     key: credentials
   config:
-    region: us-west-2
+    region: ${REGION}
     profile: default
+    kmsKeyId: ${KMS_KEY_ID}
     tagging: "ocm_environment=${CLUSTER_ENV}&schedule=hourly"
 ---
 apiVersion: velero.io/v1
@@ -568,7 +624,7 @@ spec:
     - namespace
     excludedResources: []
     storageLocation: ${CLUSTER_ID}-hourly
-    ttl: 24h0m0s
+    ttl: ${TTL}
     snapshotMoveData: true
     datamover: "velero"
     defaultVolumesToFsBackup: false
@@ -582,111 +638,497 @@ spec:
 		"${BUCKET_NAME}", config.BucketName,
 		"${CLUSTER_ENV}", config.ClusterEnv,
 		"${CLUSTER_NAME}", config.ClusterName,
+		"${REGION}", config.Region,
+		"${PREFIX}", backupPrefix,
+		"${KMS_KEY_ID}", config.KMSKeyID,
+		"${TTL}", scheduleTTL.String(),
 	)
 
 	// Perform the substitution.
 	finalYAML := replacer.Replace(template)
 
-	os.WriteFile("backup_resources.yaml", []byte(finalYAML), 0644)
-	// backupStdOut, backupStderr, backupErr := runCommand("envsubst", "<", "backup_template.yaml", ">", "backup_resources.yaml")
-	// if backupErr != nil {
-	// 	fmt.Errorf("failed to extract secret Data ID: %w, stderr: %s", backupStdOut, backupStderr)
-	// }
-	fmt.Printf("\nSuccessfully generated and saved backup_resources.yaml\n")
+	// Server-side apply creates the objects or updates the ones of an earlier run in place.
+	if err := kube.ApplyManifest(ctx, finalYAML); err != nil {
+		return "", err
+	}
+	fmt.Printf("\nSuccessfully applied the backup resources to namespace %s\n", veleroNamespace)
 
-	projectChangeStdout, projectChangeStderr, err := runCommand("oc", "project", "openshift-adp")
-	if err != nil {
-		return "", fmt.Errorf("failed to list attached role policies: %w, stderr: %s", err, projectChangeStderr)
+	return finalYAML, nil
+}
+
+// encryptionProbeKey is the object written to check the default encryption of a bucket. It is
+// kept outside the backup prefix so Velero never sees it, and deleted right after the check.
+const encryptionProbeKey = "drtest-encryption-probe"
+
+// setBucketEncryption sets SSE-KMS with keyArn as the default encryption of the bucket and checks
+// that a newly written object is encrypted with it.
+func setBucketEncryption(ctx context.Context, c *awsClient, bucketName, keyArn string) error {
+	fmt.Printf("\nSetting the default encryption of bucket '%s' to KMS key '%s'...\n", bucketName, keyArn)
+	if err := c.PutBucketEncryption(ctx, bucketName, keyArn); err != nil {
+		return fmt.Errorf("failed to set the default encryption of bucket %s: %w", bucketName, err)
 	}
-	fmt.Println("List Policies Output:\n", projectChangeStdout)
+	return verifyBucketEncryption(ctx, c, bucketName, keyArn)
+}
 
-	applyBackupStdout, applyBackuperr, err := runCommand("oc", "apply", "-f", "backup_resources.yaml")
+// verifyBucketEncryption writes a probe object without encryption headers and checks that the
+// bucket encrypted it with SSE-KMS and keyArn. In an Object Lock bucket the probe is written
+// under a governance retention and deleted bypassing it.
+func verifyBucketEncryption(ctx context.Context, c *awsClient, bucketName, keyArn string) error {
+	locked, err := c.ObjectLockEnabled(ctx, bucketName)
 	if err != nil {
-		return "", fmt.Errorf("Failed to read backup_resources file: %w, stderr: %s", err, applyBackuperr)
+		return fmt.Errorf("failed to get the object lock configuration of bucket %s: %w", bucketName, err)
 	}
-	fmt.Println("List Policies Output:\n", applyBackupStdout)
-
-	return finalYAML, nil
+	content := []byte("drtest encryption probe\n")
+	if locked {
+		// A short governance retention replaces the bucket's default one, so the probe can be deleted again.
+		err = c.PutObjectRetained(ctx, bucketName, encryptionProbeKey, content, time.Now().Add(time.Hour))
+	} else {
+		err = c.PutObject(ctx, bucketName, encryptionProbeKey, content)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the encryption probe to bucket %s: %w", bucketName, err)
+	}
+	defer func() {
+		if err := c.DeleteObjectVersions(ctx, bucketName, encryptionProbeKey, locked); err != nil {
+			fmt.Printf("Warning: failed to delete the encryption probe s3://%s/%s: %v\n", bucketName, encryptionProbeKey, err)
+		}
+	}()
+	sse, keyID, err := c.ObjectEncryption(ctx, bucketName, encryptionProbeKey)
+	if err != nil {
+		return fmt.Errorf("failed to read the encryption of the probe in bucket %s: %w", bucketName, err)
+	}
+	if c.dryRun == nil && (sse != "aws:kms" || keyID != keyArn) {
+		return fmt.Errorf("new objects in bucket %s are encrypted with %q and key %q, expected aws:kms and %s", bucketName, sse, keyID, keyArn)
+	}
+	fmt.Printf("New objects in bucket '%s' are encrypted with KMS key '%s'.\n", bucketName, keyArn)
+	return nil
 }
 
-func validateBackupCreated(clusterId string) {
-	validateScheduleStdout, validateScheduleerr, err := runCommand("oc", "get", "schedule")
+// verifyBucketRegion checks that the bucket is located in region.
+func verifyBucketRegion(ctx context.Context, c *awsClient, bucketName, region string) error {
+	location, err := c.BucketRegion(ctx, bucketName)
 	if err != nil {
-		fmt.Errorf("schedule not found: %w, stderr: %s", err, validateScheduleerr)
+		return fmt.Errorf("failed to get the location of bucket %s: %w", bucketName, err)
 	}
-	if strings.Contains(validateScheduleStdout, clusterId) {
-		fmt.Println("Cluster Schedule is present:")
+	if c.dryRun == nil && location != region {
+		return fmt.Errorf("bucket %s is located in %s, not in %s", bucketName, location, region)
 	}
-
+	fmt.Printf("Bucket '%s' is located in '%s'.\n", bucketName, region)
+	return nil
 }
 
-func main() {
-	// --- IMPORTANT: Replace these placeholder values with your actual cluster details ---
-	// You can get these from your ROSA cluster creation process.
-	clusterID := os.Args[1]   // e.g., "abc123def456"
-	clusterName := os.Args[2] // e.g., "my-rosa-cluster"
-	clusterEnv := os.Args[3]  // e.g., "local", "int", "john.doe"
-	mcName := os.Args[4]      // e.g., "hs-mc-n1j3kghkg", hive's mangement cluster name.
-	awsProfile := os.Args[5]  // e.g., "dr-account" Your local aws config should have this name.
-	awsRegion := os.Args[6]   // e.g., us-west-2 can be whichever region you are looking for.
-	// ----------------------------------------------------------------------------------
-	var bucketName string
-	// Call the setupCluster function with your cluster details
-	err := setupCluster(clusterID, clusterName, clusterEnv)
+// checkManagedCluster checks that the management cluster is registered with the service cluster
+// and reported as available there.
+func checkManagedCluster(ctx context.Context, sc *kubeClient, mcName string) error {
+	fmt.Printf("Checking that management cluster '%s' is registered with the service cluster...\n", mcName)
+	managed, err := sc.Get(ctx, managedClusterGVR, "", mcName)
+	if isKubeNotFound(err) {
+		return fmt.Errorf("management cluster %s is not registered with the service cluster", mcName)
+	}
 	if err != nil {
-		log.Fatalf("Cluster setup failed: %v", err)
+		return fmt.Errorf("failed to get managed cluster %s: %w", mcName, err)
+	}
+	conditions, _, _ := unstructured.NestedSlice(managed.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, _ := c.(map[string]any)
+		if condition["type"] == "ManagedClusterConditionAvailable" && condition["status"] == "True" {
+			fmt.Println("Management cluster is available on the service cluster.")
+			return nil
+		}
+	}
+	fmt.Println("Warning: Management cluster is not reported as available by the service cluster. Please review its ManagedCluster status.")
+	return nil
+}
+
+// validateBackupCreated checks that the hourly Schedule for the cluster exists.
+func validateBackupCreated(ctx context.Context, kube *kubeClient, clusterId string) error {
+	schedule, err := kube.Get(ctx, scheduleGVR, veleroNamespace, clusterId+"-hourly")
+	if isKubeNotFound(err) {
+		return fmt.Errorf("no schedule found for cluster %s", clusterId)
 	}
-	// Call the createS3Bucket function with your AWS details
-	bucketName, err = createS3Bucket(awsProfile, awsRegion)
 	if err != nil {
-		log.Printf("AWS S3 bucket creation failed: %v", err)
-		// Do not exit fatally here, allow other independent operations to proceed.
+		return fmt.Errorf("failed to get schedule %s-hourly: %w", clusterId, err)
+	}
+	fmt.Printf("Cluster Schedule is present: %s (phase: %s)\n", schedule.GetName(), nestedString(schedule, "status", "phase"))
+	return nil
+}
+
+// configureOptions holds the flags accepted by the configure subcommand.
+type configureOptions struct {
+	ClusterID          string
+	ClusterName        string
+	ClusterEnv         string
+	MCName             string
+	AWSRegion          string
+	Resume             bool
+	NoRollback         bool
+	CreateOIDCProvider bool
+	KMSAdmins          stringList
+	Immutable          bool
+	LockMode           string
+	ReplicaRegion      string
+	Exec               executorOptions
+	State              stateOptions
+	AWS                awsOptions
+	Vault              vaultOptions
+	OCM                ocmOptions
+	MCKube             kubeOptions
+	SCKube             kubeOptions
+}
+
+// configureRun carries what the configure steps of one run share.
+type configureRun struct {
+	ctx       context.Context
+	opts      configureOptions
+	ledger    *Ledger
+	aws       *awsClient
+	account   string
+	callerArn string
+	vault     *awsVault
+	ocm       *ocmClient
+	mc        *kubeClient
+	sc        *kubeClient
+}
+
+// crossAccount reports whether the bucket and key are created in another account than the role.
+func (r *configureRun) crossAccount() bool {
+	return r.vault.account != r.account
+}
+
+// configureStep is one checkpointed step of configure. A step reads the outputs of
+// earlier steps from the ledger and records its own outputs in it, and declares the
+// cluster it talks to.
+type configureStep struct {
+	name   string
+	target clusterTarget
+	run    func(r *configureRun) error
+}
+
+// configureSteps lists the configure steps in the order they run.
+var configureSteps = []configureStep{
+	{"setup-cluster", targetSC, stepSetupCluster},
+	{"s3-bucket", targetNone, stepCreateS3Bucket},
+	{"oidc", targetNone, stepCreateOIDCConfig},
+	{"iam-role", targetNone, stepCreateIAMRole},
+	{"kms", targetNone, stepCreateKMSKeyAndPolicy},
+	{"bucket-encryption", targetNone, stepSetBucketEncryption},
+	{"replication", targetNone, stepCreateReplica},
+	{"backup-resources", targetMC, stepCreateBackupResources},
+}
+
+// stepSetupCluster checks the health of the cluster and that its management cluster is
+// registered with the service cluster.
+func stepSetupCluster(r *configureRun) error {
+	if err := setupCluster(r.opts.ClusterID, r.opts.ClusterName, r.opts.ClusterEnv); err != nil {
+		return err
+	}
+	if r.sc == nil {
+		fmt.Println("Skipping the service cluster checks, pass --sc-kubeconfig or --sc-context to run them.")
+		return nil
+	}
+	return checkManagedCluster(r.ctx, r.sc, r.opts.MCName)
+}
+
+// stepCreateS3Bucket creates the backup bucket, with Object Lock when --immutable is set.
+// A bucket created by an earlier failed attempt is kept in the ledger and reused.
+func stepCreateS3Bucket(r *configureRun) error {
+	var lockMode string
+	var lockDays int32
+	if r.opts.Immutable {
+		lockMode, lockDays = strings.ToUpper(r.opts.LockMode), objectLockDays()
 	}
+	if r.ledger.BucketName != "" {
+		// The bucket of an earlier failed attempt keeps the Object Lock setting it was created with.
+		lockMode, lockDays = r.ledger.ObjectLockMode, r.ledger.ObjectLockDays
+	}
+	bucketName, err := createS3Bucket(r.ctx, r.vault.awsClient, r.ledger.BucketName, lockMode, lockDays)
+	if bucketName != "" {
+		r.ledger.BucketName = bucketName
+		r.ledger.ObjectLockMode = lockMode
+		r.ledger.ObjectLockDays = lockDays
+	}
+	return err
+}
 
-	// Call the createOIDCConfig function with your management cluster details
-	mcOIDCUrl, mcOIDC, mcOIDCArn, err := createOIDCConfig(mcName, awsRegion, clusterID)
+// stepCreateOIDCConfig discovers the OIDC provider of the management cluster.
+func stepCreateOIDCConfig(r *configureRun) error {
+	mcOIDCUrl, mcOIDC, mcOIDCArn, created, err := createOIDCConfig(r.ctx, r.ocm, r.aws, r.opts.MCName, r.opts.AWSRegion, r.opts.ClusterID, r.opts.CreateOIDCProvider)
 	if err != nil {
-		log.Fatalf("OIDC configuration failed: %v", err)
+		return err
 	}
 	fmt.Printf("\nFinal OIDC URL: %s\nFinal OIDC ID: %s\nFinal OIDC Arn: %s\n", mcOIDCUrl, mcOIDC, mcOIDCArn)
+	r.ledger.OIDCURL = mcOIDCUrl
+	r.ledger.OIDCIssuer = mcOIDC
+	r.ledger.OIDCProviderArn = mcOIDCArn
+	r.ledger.OIDCProviderCreated = created
+	return nil
+}
 
-	// Call the createIAMRole function with your AWS and OIDC details
-	roleArn, err := createIAMRole(awsProfile, mcName, clusterID, mcOIDCUrl, mcOIDC, mcOIDCArn)
+// stepCreateIAMRole creates the backup role trusted by the Velero service account. A bucket in a
+// vault account also grants the role access in its bucket policy.
+func stepCreateIAMRole(r *configureRun) error {
+	roleArn, err := createIAMRole(r.ctx, r.aws, r.opts.MCName, r.opts.ClusterID, r.ledger.BucketName, r.ledger.OIDCURL, r.ledger.OIDCIssuer, r.ledger.OIDCProviderArn)
 	if err != nil {
-		log.Fatalf("IAM role creation and policy attachment failed: %v", err)
+		return err
 	}
 	fmt.Printf("\nFinal IAM Role ARN: %s\n", roleArn)
+	r.ledger.RoleName = roleNameFromArn(roleArn)
+	r.ledger.RoleArn = roleArn
+	r.ledger.addInlinePolicy(bucketAccessPolicyName)
+	if r.crossAccount() {
+		return grantVaultBucketAccess(r.ctx, r.vault.awsClient, r.ledger.BucketName, roleArn, false)
+	}
+	return nil
+}
 
-	// Call the createKMSKeyAndPolicy function with your AWS, cluster, and role details
-	kmsArn, kmsIAMPolicyName, err := createKMSKeyAndPolicy(awsProfile, clusterID, clusterEnv, awsRegion, roleArn)
+// stepCreateKMSKeyAndPolicy creates the backup KMS key and grants the backup role access to it.
+// A key created by an earlier failed attempt is kept in the ledger and reused.
+func stepCreateKMSKeyAndPolicy(r *configureRun) error {
+	var adminPatterns []string
+	if r.opts.Exec.DryRun && len(r.opts.KMSAdmins) == 0 {
+		// The caller is only known as the placeholder of the planned identity call, which the
+		// key policy then names as its administrator.
+		adminPatterns = []string{r.vault.callerArn}
+	} else {
+		var err error
+		if adminPatterns, err = kmsAdminPatterns(r.opts.KMSAdmins, r.vault.account, r.vault.callerArn); err != nil {
+			return err
+		}
+	}
+	kmsArn, kmsIAMPolicyName, kmsIAMPolicyArn, err := createKMSKeyAndPolicy(r.ctx, r.aws, r.vault.awsClient, r.opts.ClusterID, r.opts.ClusterEnv, r.opts.AWSRegion, r.vault.account, r.ledger.RoleArn, r.ledger.KMSKeyArn, adminPatterns)
+	r.ledger.KMSKeyArn = kmsArn
 	if err != nil {
-		log.Fatalf("KMS key and policy creation failed: %v", err)
+		return err
 	}
 	fmt.Printf("\nFinal KMS ARN: %s\nFinal KMS IAM Policy Name: %s\n", kmsArn, kmsIAMPolicyName)
+	r.ledger.KMSPolicyName = kmsIAMPolicyName
+	r.ledger.KMSPolicyArn = kmsIAMPolicyArn
+	r.ledger.addAttachedPolicy(kmsIAMPolicyArn)
+	return nil
+}
 
-	secretData, err := GenerateAWSRoleSecret(roleArn, "aws_role.txt")
+// stepSetBucketEncryption makes the backup KMS key the default encryption of the bucket.
+func stepSetBucketEncryption(r *configureRun) error {
+	return setBucketEncryption(r.ctx, r.vault.awsClient, r.ledger.BucketName, r.ledger.KMSKeyArn)
+}
+
+// stepCreateBackupResources applies the Velero Secret, BackupStorageLocation and Schedule.
+func stepCreateBackupResources(r *configureRun) error {
+	opts, ledger := r.opts, r.ledger
+	// Velero reaches the bucket in the region of the BSL, it has to be where the bucket really is.
+	if err := verifyBucketRegion(r.ctx, r.vault.awsClient, ledger.BucketName, ledger.Region); err != nil {
+		return err
+	}
+	secretData, err := GenerateAWSRoleSecret(ledger.RoleArn, ledger.Region, "aws_role.txt")
 	if err != nil {
-		fmt.Printf("Error generating secret data: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("error generating secret data: %w", err)
 	}
-	fmt.Printf("Secret data is as follows:%s", secretData)
 
-	// --- Step 2: Define the configuration for the backup resources ---
-	// In a real application, these values would come from environment variables,
-	// command-line flags, or a configuration file.
+	// --- Define the configuration for the backup resources ---
 	config := BackupConfig{
 		SecretData:  secretData,
-		ClusterID:   clusterID,
-		ClusterName: clusterName,
-		ClusterEnv:  clusterEnv,
-		BucketName:  bucketName,
+		ClusterID:   opts.ClusterID,
+		ClusterName: opts.ClusterName,
+		ClusterEnv:  opts.ClusterEnv,
+		BucketName:  ledger.BucketName,
+		Region:      ledger.Region,
+		KMSKeyID:    ledger.KMSKeyArn,
 	}
 
-	// --- Step 3: Generate the final backup_resources.yaml content ---
-	backupYAML, err := CreateBackupResources(config)
+	// The names are recorded first, teardown deletes exactly these objects even when applying
+	// them fails halfway.
+	ledger.Namespace = veleroNamespace
+	ledger.SecretName = opts.ClusterID + "-backup-role"
+	ledger.BSLName = opts.ClusterID + "-hourly"
+	ledger.ScheduleName = opts.ClusterID + "-hourly"
+
+	// --- Generate and apply the final backup_resources.yaml content ---
+	backupYAML, err := CreateBackupResources(r.ctx, r.mc, config)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error generating backup resources: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("error generating backup resources: %w", err)
+	}
+	fmt.Printf("Backup resources are as follows:\n%s", strings.ReplaceAll(backupYAML, secretData, "<redacted>"))
+
+	if ledger.ReplicaBSLName != "" {
+		if err := r.mc.ApplyManifest(r.ctx, replicaBSLManifest(ledger)); err != nil {
+			return fmt.Errorf("error applying the replica backup storage location: %w", err)
+		}
+	}
+	return nil
+}
+
+// runConfigure parses the configure flags and runs every setup step for the cluster.
+// Each step checkpoints its outputs in the state ledger, so --resume skips the completed
+// steps of an earlier run and reuses their outputs instead of creating new resources.
+func runConfigure(args []string) error {
+	var opts configureOptions
+	fs := flag.NewFlagSet("configure", flag.ExitOnError)
+	fs.StringVar(&opts.ClusterID, "cluster-id", "", "ROSA HCP cluster ID, e.g. abc123def456")
+	fs.StringVar(&opts.ClusterName, "cluster-name", "", "ROSA HCP cluster name, e.g. my-rosa-cluster")
+	fs.StringVar(&opts.ClusterEnv, "cluster-env", "", "OCM environment of the cluster, e.g. local, int, john.doe")
+	fs.StringVar(&opts.MCName, "mc-name", "", "hive's management cluster name, e.g. hs-mc-n1j3kghkg")
+	fs.StringVar(&opts.AWSRegion, "region", "", "AWS region to create the backup resources in, e.g. us-west-2")
+	fs.BoolVar(&opts.Resume, "resume", false, "skip the steps completed by an earlier run and reuse their outputs")
+	fs.BoolVar(&opts.CreateOIDCProvider, "create-oidc-provider", false, "create the IAM OIDC provider of the management cluster when the account has none")
+	fs.Var(&opts.KMSAdmins, "kms-admin", "administrator of the backup KMS key: an IAM user or role ARN, or sso:<permission set name>; repeatable, defaults to the caller")
+	fs.BoolVar(&opts.Immutable, "immutable", false, "create the bucket with S3 Object Lock, so backups cannot be deleted before the schedule TTL has passed")
+	fs.StringVar(&opts.LockMode, "lock-mode", "governance", "Object Lock retention mode with --immutable: governance, or compliance which not even the account root can shorten")
+	fs.StringVar(&opts.ReplicaRegion, "replica-region", "", "replicate the backups to a bucket in this region, registered as a read-only BSL for restores")
+	fs.BoolVar(&opts.NoRollback, "no-rollback", false, "keep the resources created by a failed run for debugging instead of deleting them")
+	opts.Exec.register(fs)
+	opts.State.register(fs)
+	opts.AWS.register(fs)
+	opts.Vault.register(fs)
+	opts.OCM.register(fs)
+	opts.MCKube.register(fs, "mc", targetMC)
+	opts.SCKube.register(fs, "sc", targetSC)
+	fs.Parse(args)
+	if err := requireFlags(fs, "cluster-id", "cluster-name", "cluster-env", "mc-name", "region"); err != nil {
+		return err
+	}
+	if opts.LockMode != "governance" && opts.LockMode != "compliance" {
+		return fmt.Errorf("invalid --lock-mode %q, expected governance or compliance", opts.LockMode)
+	}
+	// Catch a malformed --kms-admin before anything is created, the account is only known later.
+	for _, admin := range opts.KMSAdmins {
+		if _, err := kmsAdminPattern(admin, ""); err != nil {
+			return err
+		}
+	}
+	if err := opts.Exec.install(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	awsc, err := newAWSClient(ctx, opts.AWSRegion, opts.AWS, opts.Exec)
+	if err != nil {
+		return err
+	}
+	ocm, err := newOCMClient(opts.OCM, opts.Exec)
+	if err != nil {
+		return err
+	}
+	account, callerArn, err := awsc.PrintIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	vault, err := openVault(ctx, awsc, account, callerArn, opts.Vault, opts.Exec)
+	if err != nil {
+		return err
+	}
+	run := &configureRun{ctx: ctx, opts: opts, aws: awsc, account: account, callerArn: callerArn, vault: vault, ocm: ocm}
+	if err := run.connectClusters(); err != nil {
+		return err
+	}
+	store, err := opts.State.open(ctx, opts.Exec.DryRun, awsc, run.mc)
+	if err != nil {
+		return err
+	}
+
+	ledger, err := store.Load(opts.ClusterID)
+	switch {
+	case errors.Is(err, errLedgerNotFound):
+		if opts.Resume {
+			return fmt.Errorf("no state ledger found for cluster %s, nothing to resume", opts.ClusterID)
+		}
+		ledger = &Ledger{
+			ClusterID:   opts.ClusterID,
+			ClusterName: opts.ClusterName,
+			ClusterEnv:  opts.ClusterEnv,
+			MCName:      opts.MCName,
+			Region:      opts.AWSRegion,
+		}
+		if run.crossAccount() {
+			ledger.VaultAccountID = vault.account
+		}
+	case err != nil:
+		return err
+	case !opts.Resume:
+		// Starting over would create a second bucket and key next to the ones already recorded.
+		return fmt.Errorf("a state ledger already exists for cluster %s, rerun with --resume or run teardown first", opts.ClusterID)
+	case ledger.MCName != opts.MCName || ledger.Region != opts.AWSRegion:
+		return fmt.Errorf("cannot resume cluster %s: the ledger was recorded for management cluster %s in %s",
+			opts.ClusterID, ledger.MCName, ledger.Region)
+	default:
+		if err := checkVaultAccount(ledger, vault); err != nil {
+			return fmt.Errorf("cannot resume cluster %s: %w", opts.ClusterID, err)
+		}
+	}
+
+	// The ledger as it was before this run is restored once the resources created by a failed run are rolled back.
+	before := ledger.clone()
+	run.ledger = ledger
+	for _, step := range configureSteps {
+		if ledger.completed(step.name) {
+			fmt.Printf("\nSkipping step '%s', already completed by an earlier run.\n", step.name)
+			continue
+		}
+		if step.target != targetNone {
+			fmt.Printf("\nStep '%s' targets the %s.\n", step.name, step.target)
+		}
+		err := step.run(run)
+		if err == nil {
+			ledger.markCompleted(step.name)
+		}
+		// Save the outputs even when the step failed, a resumed run picks them up.
+		if saveErr := saveLedger(store, ledger); saveErr != nil {
+			return saveErr
+		}
+		if err != nil {
+			return rollbackFailedRun(opts, store, before, fmt.Errorf("step '%s' failed: %w", step.name, err))
+		}
+	}
+	return nil
+}
+
+// connectClusters builds the clients of the management cluster and, when its flags are given, the
+// service cluster, and verifies that each one talks to the API server OCM reports for it.
+func (r *configureRun) connectClusters() error {
+	var err error
+	if r.mc, err = newKubeClient(r.opts.MCKube, r.opts.Exec); err != nil {
+		return fmt.Errorf("management cluster: %w", err)
+	}
+	if r.opts.SCKube.set() {
+		if r.sc, err = newKubeClient(r.opts.SCKube, r.opts.Exec); err != nil {
+			return fmt.Errorf("service cluster: %w", err)
+		}
+	}
+	if r.opts.Exec.DryRun {
+		return nil
+	}
+
+	mc, err := r.ocm.FindManagementCluster(r.ctx, r.opts.MCName, r.opts.AWSRegion)
+	if err != nil {
+		return fmt.Errorf("preflight failed: %w", err)
+	}
+	if err := preflightCluster(r.ctx, r.ocm, r.mc, targetMC, mc); err != nil {
+		return fmt.Errorf("preflight failed: %w", err)
+	}
+	if r.sc != nil {
+		if err := preflightCluster(r.ctx, r.ocm, r.sc, targetSC, mc); err != nil {
+			return fmt.Errorf("preflight failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// rollbackFailedRun undoes the resources created by a failed configure run, unless --no-rollback is set.
+// When every compensating action succeeds the ledger is restored to its state before the run;
+// otherwise it keeps listing everything, so teardown can finish the job.
+func rollbackFailedRun(opts configureOptions, store StateStore, before *Ledger, runErr error) error {
+	if opts.NoRollback {
+		return fmt.Errorf("%w (resources kept because of --no-rollback, rerun with --resume once the cause is fixed)", runErr)
+	}
+
+	failed := rollback.run()
+	if len(failed) > 0 {
+		return fmt.Errorf("%w; rollback left resources behind, run teardown to remove them: %w", runErr, errors.Join(failed...))
+	}
+	if len(before.CompletedSteps) == 0 {
+		if err := store.Delete(opts.ClusterID); err != nil {
+			return fmt.Errorf("%w; failed to delete state ledger after rollback: %w", runErr, err)
+		}
+	} else if err := saveLedger(store, before); err != nil {
+		return fmt.Errorf("%w; %w", runErr, err)
 	}
-	fmt.Printf("Secret data is as follows:%s", backupYAML)
+	return fmt.Errorf("%w (created resources were rolled back)", runErr)
 }
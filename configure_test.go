@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestConfigureDryRunWithoutKMSAdmin plans a configure run without --kms-admin. The caller is only
+// known as a placeholder then, and the plan must still reach the key policy.
+func TestConfigureDryRunWithoutKMSAdmin(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "aws-credentials"))
+
+	out := runCaptured(t, runConfigure,
+		"--cluster-id", "abc",
+		"--cluster-name", "n",
+		"--cluster-env", "int",
+		"--mc-name", "mc",
+		"--region", "us-west-2",
+		"--state-dir", t.TempDir(),
+		"--dry-run",
+	)
+	for _, want := range []string{
+		"aws sts:GetCallerIdentity",
+		"Key administrators: <output-1>",
+		"aws kms:CreateKey",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry-run plan does not contain %q\noutput:\n%s", want, out)
+		}
+	}
+}
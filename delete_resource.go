@@ -2,217 +2,937 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"os/exec"
+	"slices"
 	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-var initialListCmd string
-var initialListArgs []string
+// roleNamePrefix is the prefix of the backup role created for every cluster.
+const roleNamePrefix = "rosa-hcp-bkp-"
+
+// protectTagKey is the tag that protects a bucket or role from teardown when set to "true".
+const protectTagKey = "dr-protect"
 
-type BackupStorageLocation struct {
-	Spec Spec `json:"spec"`
+// teardownResources maps the resource names accepted by deleteResource to their API resources.
+// Backups are deleted by deleteScheduleBackups instead, so Velero removes their data too.
+var teardownResources = map[string]schema.GroupVersionResource{
+	"bsl":              bslGVR,
+	"schedule":         scheduleGVR,
+	"secret":           secretGVR,
+	"backuprepository": backupRepositoryGVR,
 }
 
-// Spec matches the nested "spec" object.
-type Spec struct {
-	ObjectStorage ObjectStorage `json:"objectStorage"`
+const (
+	// scheduleNameLabel is the label Velero puts on the backups created by a Schedule.
+	scheduleNameLabel = "velero.io/schedule-name"
+	// backupNameLabel is the label Velero puts on the DeleteBackupRequests of a backup.
+	backupNameLabel = "velero.io/backup-name"
+	// storageLocationLabel is the label Velero puts on the BackupRepositories of a BSL.
+	storageLocationLabel = "velero.io/storage-location"
+)
+
+// deleteRequestPollInterval is how often the DeleteBackupRequests are checked. The replay tests
+// shorten it.
+var deleteRequestPollInterval = 5 * time.Second
+
+// deleteResource deletes one Velero object of a cluster by the name recorded in its ledger. An
+// empty name means the ledger recorded none, so nothing is deleted.
+func deleteResource(ctx context.Context, kube *kubeClient, resourceToDelete, name string) {
+	if name == "" {
+		fmt.Printf("No %s is recorded for the cluster\n", resourceToDelete)
+		return
+	}
+	fmt.Printf("--- Deleting %s %s ---\n", resourceToDelete, name)
+	err := kube.Delete(ctx, teardownResources[resourceToDelete], veleroNamespace, name)
+	switch {
+	case isKubeNotFound(err):
+		fmt.Printf("%s %s does not exist\n", resourceToDelete, name)
+	case err != nil:
+		fmt.Printf("failed to delete %s %s: %v\n", resourceToDelete, name, err)
+	default:
+		fmt.Printf("Resource deleted: %s\n", name)
+	}
 }
 
-// ObjectStorage matches the nested "objectStorage" object.
-type ObjectStorage struct {
-	Bucket string `json:"bucket"`
+// deleteBackupRepositories deletes the BackupRepositories Velero created for a BSL, selected by
+// the storage location label rather than by name.
+func deleteBackupRepositories(ctx context.Context, kube *kubeClient, bslName string) {
+	if bslName == "" {
+		return
+	}
+	items, err := kube.List(ctx, backupRepositoryGVR, veleroNamespace, storageLocationLabel+"="+bslName)
+	if err != nil {
+		fmt.Printf("failed to list the backuprepositories of BSL %s: %v\n", bslName, err)
+		return
+	}
+	fmt.Printf("%d backuprepositories of BSL %s to delete.\n", len(items), bslName)
+	for _, item := range items {
+		deleteResource(ctx, kube, "backuprepository", item.GetName())
+	}
 }
 
-// runCommand captures the stdout of a given command and returns it as a slice of strings,
-// where each string is a line from the command's output.
-func runCommand(command string, args ...string) ([]string, error) {
-	fmt.Printf("Executing command: %s %s\n", command, strings.Join(args, " "))
+// deleteMatchingResources handles the secrets and backuprepositories left in openshift-adp whose
+// name contains the cluster ID. Without a ledger they are all teardown can go by, but the ID may
+// also be part of another cluster's names, so they are only deleted when confirmed is set and
+// listed otherwise.
+func deleteMatchingResources(ctx context.Context, kube *kubeClient, clusterId string, confirmed bool) {
+	for _, resource := range []string{"secret", "backuprepository"} {
+		items, err := kube.List(ctx, teardownResources[resource], veleroNamespace, "")
+		if err != nil {
+			fmt.Printf("failed to list the %s items: %v\n", resource, err)
+			continue
+		}
+		var matches []string
+		for _, item := range items {
+			if strings.Contains(item.GetName(), clusterId) {
+				matches = append(matches, item.GetName())
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		if !confirmed {
+			fmt.Printf("Kept %s items whose name contains %s: %s; pass --delete-matching to delete them\n", resource, clusterId, matches)
+			continue
+		}
+		fmt.Printf("The entire list of %s items to remove: %s\n", resource, matches)
+		for _, name := range matches {
+			deleteResource(ctx, kube, resource, name)
+		}
+	}
+}
 
-	cmd := exec.Command(command, args...) // Create the command
-	stdout, err := cmd.StdoutPipe()       // Get a pipe to read the standard output
+// deleteScheduleBackups deletes the backups created by a Schedule with a DeleteBackupRequest each,
+// so Velero removes their data from the bucket and their snapshots along with the Backup objects.
+// It waits up to timeout for Velero to process the requests and returns the outcome per backup.
+// It must run while the bucket, the role and the BSL still exist, Velero needs them to delete.
+func deleteScheduleBackups(ctx context.Context, kube *kubeClient, scheduleName string, timeout time.Duration) []teardownOutcome {
+	backups, err := kube.List(ctx, backupGVR, veleroNamespace, scheduleNameLabel+"="+scheduleName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get StdoutPipe: %w", err)
+		return []teardownOutcome{{resource: "backups of schedule " + scheduleName, outcome: fmt.Sprintf("failed to list: %s", err)}}
 	}
+	fmt.Printf("%d backups of schedule %s to delete.\n", len(backups), scheduleName)
 
-	if err := cmd.Start(); err != nil { // Start the command
-		return nil, fmt.Errorf("failed to start command: %w", err)
+	var outcomes []teardownOutcome
+	pending := map[string]string{}
+	for _, backup := range backups {
+		request := &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "velero.io/v1",
+			"kind":       "DeleteBackupRequest",
+			"metadata": map[string]any{
+				// A generated name never matches a processed request left by an earlier teardown.
+				"generateName": backup.GetName() + "-",
+				"namespace":    veleroNamespace,
+				"labels":       map[string]any{backupNameLabel: backup.GetName()},
+			},
+			"spec": map[string]any{"backupName": backup.GetName()},
+		}}
+		request, err := kube.Create(ctx, request)
+		if err != nil {
+			outcomes = append(outcomes, teardownOutcome{resource: "backup " + backup.GetName(), outcome: fmt.Sprintf("failed to request the deletion: %s", err)})
+			continue
+		}
+		fmt.Printf("DeleteBackupRequest %s created for backup %s\n", request.GetName(), backup.GetName())
+		pending[backup.GetName()] = request.GetName()
 	}
 
-	//var outputLines []string
-	var firstColumnList []string
-	scanner := bufio.NewScanner(stdout) // Create a scanner to read line by line
-	for scanner.Scan() {
-		line := scanner.Text() // Read a line and trim whitespace
-		// if line != "" {                           // Only add non-empty lines
-		// 	outputLines = append(outputLines, line)
-		// }
-		fields := strings.Fields(line) // Split the line by whitespace
-		if len(fields) > 0 {
-			firstColumnList = append(firstColumnList, fields[0]) // Add the first field to the list
+	deadline := time.Now().Add(timeout)
+	for len(pending) > 0 {
+		for backup, request := range pending {
+			dbr, err := kube.Get(ctx, deleteRequestGVR, veleroNamespace, request)
+			var outcome string
+			switch {
+			case isKubeNotFound(err):
+				// Velero removes the requests of a backup once it is deleted.
+				outcome = "deleted"
+			case err != nil:
+				fmt.Printf("failed to get DeleteBackupRequest %s: %s\n", request, err)
+				continue
+			case nestedString(dbr, "status", "phase") != "Processed":
+				continue
+			default:
+				outcome = "deleted"
+				if errs, _, _ := unstructured.NestedStringSlice(dbr.Object, "status", "errors"); len(errs) > 0 {
+					outcome = "failed: " + strings.Join(errs, "; ")
+				}
+			}
+			fmt.Printf("backup %s: %s\n", backup, outcome)
+			outcomes = append(outcomes, teardownOutcome{resource: "backup " + backup, outcome: outcome})
+			delete(pending, backup)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			for backup, request := range pending {
+				outcomes = append(outcomes, teardownOutcome{resource: "backup " + backup,
+					outcome: fmt.Sprintf("not processed after %s, see DeleteBackupRequest %s", timeout, request)})
+			}
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return append(outcomes, teardownOutcome{resource: "backups of schedule " + scheduleName, outcome: ctx.Err().Error()})
+		case <-time.After(deleteRequestPollInterval):
 		}
 	}
+	return outcomes
+}
 
-	if err := scanner.Err(); err != nil { // Check for scanner errors
-		return nil, fmt.Errorf("error reading command output: %w", err)
+// discoverLedger rebuilds the ledger of a cluster configured before drtest kept one.
+// The bucket name is read from the BSL and the role name is derived from the naming convention.
+// The KMS key and policy are found by their cluster tag, the policy also among the role's policies.
+// A failed tag lookup is returned, a key or policy it misses would be left behind by teardown.
+func discoverLedger(ctx context.Context, c *awsClient, kube *kubeClient, clusterId, mcName string) (*Ledger, error) {
+	ledger := &Ledger{
+		ClusterID: clusterId,
+		MCName:    mcName,
+		RoleName:  roleNamePrefix + mcName + "-" + clusterId,
+		// configure names the Velero objects after the cluster.
+		Namespace:    veleroNamespace,
+		SecretName:   clusterId + "-backup-role",
+		BSLName:      clusterId + "-hourly",
+		ScheduleName: clusterId + "-hourly",
 	}
 
-	if err := cmd.Wait(); err != nil { // Wait for the command to finish and check for errors
-		return nil, fmt.Errorf("command exited with error: %w", err)
+	// --- Get S3 bucket name ---
+	bsl, err := kube.Get(ctx, bslGVR, veleroNamespace, ledger.BSLName)
+	if err != nil {
+		fmt.Printf("failed to get BSL %s: %v\n", ledger.BSLName, err)
+	} else {
+		ledger.BucketName = nestedString(bsl, "spec", "objectStorage", "bucket")
 	}
+	if kube.dryRun != nil {
+		// The planned get returns no BSL, stand in for the bucket it would name.
+		ledger.BucketName = fmt.Sprintf("<bucket of %s-hourly>", clusterId)
+	}
+	fmt.Println("S3 bucket name is", ledger.BucketName)
 
-	return firstColumnList, nil
+	// List all policies attached to the role
+	ledger.AttachedPolicyArns, err = c.ListAttachedRolePolicies(ctx, ledger.RoleName)
+	if err != nil {
+		fmt.Printf("Policies are empty or role does not exist: %s\n", err)
+	}
+	fmt.Printf("Role policies list Output is as follows... %s\n", ledger.AttachedPolicyArns)
+	ledger.InlinePolicyNames, err = c.ListRolePolicies(ctx, ledger.RoleName)
+	if err != nil {
+		fmt.Printf("Inline policies are empty or role does not exist: %s\n", err)
+	}
+	fmt.Printf("Role inline policies: %s\n", ledger.InlinePolicyNames)
+
+	// --- Get the KMS policy and key ---
+	// The policy is attached to the role, or found by its tag once it was detached.
+	for _, policyArn := range ledger.AttachedPolicyArns {
+		if strings.HasSuffix(policyArn, ":policy/"+kmsPolicyNamePrefix+clusterId) {
+			ledger.KMSPolicyArn = policyArn
+		}
+	}
+	if ledger.KMSPolicyArn == "" {
+		tagged, err := c.FindPoliciesByTag(ctx, kmsPolicyNamePrefix, clusterTagKey, clusterId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the IAM policies tagged %s=%s: %w", clusterTagKey, clusterId, err)
+		}
+		if len(tagged) > 0 {
+			ledger.KMSPolicyArn = tagged[0]
+		}
+	}
+	fmt.Println("KMS IAM policy is", ledger.KMSPolicyArn)
+	// The key is in the region of the bucket.
+	keyClient := c
+	if ledger.BucketName != "" {
+		if keyClient, err = c.forBucket(ctx, ledger.BucketName); err != nil {
+			fmt.Printf("failed to find the region of bucket %s: %s\n", ledger.BucketName, err)
+			keyClient = c
+		}
+	}
+	keys, err := keyClient.FindKeysByTag(ctx, clusterTagKey, clusterId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the KMS keys tagged %s=%s: %w", clusterTagKey, clusterId, err)
+	}
+	if len(keys) > 0 {
+		ledger.KMSKeyArn = keys[0]
+		if len(keys) > 1 {
+			fmt.Printf("Warning: %d KMS keys are tagged %s=%s, only %s is deleted: %s\n", len(keys), clusterTagKey, clusterId, keys[0], strings.Join(keys[1:], ", "))
+		}
+	}
+	fmt.Println("KMS key is", ledger.KMSKeyArn)
+	return ledger, nil
 }
 
-// deleteBackup removes items from the original list that are present in the itemsToRemove list.
-// It deletes the list items.
-func deleteResource(resourceToDelete, clusterId string) {
+// teardownOutcome is what teardown did with one KMS key or customer managed policy.
+type teardownOutcome struct {
+	resource string
+	outcome  string
+}
 
-	var backupList []string
-	//var itemsToRemove []string
-	initialListCmd = "oc"
-	if resourceToDelete == "schedule" || resourceToDelete == "bsl" {
-		var cmdArgs []string
-		var cmdCommand = "oc"
-		initialListArgs = []string{"get", resourceToDelete, clusterId + "-hourly"}
-		fmt.Println("--- Getting Initial List ---", initialListArgs)
-		itemsToRemove, err := runCommand(initialListCmd, initialListArgs...)
-		if err != nil {
-			fmt.Printf("Error getting initial list: %v\n", err)
-			return
+// cleanupAWSResources performs a series of AWS cleanup operations.
+// It deletes the IAM roles and policies, the replication and the S3 buckets recorded in the cluster's
+// ledger, and schedules the deletion of their KMS keys after pendingDays. The buckets are emptied
+// with purgeWorkers parallel workers. The buckets, their keys and the replication role are deleted
+// with vault, the account they live in. The name of every role, bucket and key that is deleted is
+// cleared from the ledger. It returns the outcome for each key and customer managed policy, and
+// an error for the roles that could not be deleted.
+func cleanupAWSResources(ctx context.Context, c, vault *awsClient, ledger *Ledger, pendingDays int32, purgeWorkers int) ([]teardownOutcome, error) {
+	var outcomes []teardownOutcome
+	report := func(resource, outcome string) {
+		fmt.Printf("%s: %s\n", resource, outcome)
+		outcomes = append(outcomes, teardownOutcome{resource: resource, outcome: outcome})
+	}
+
+	// --- IAM Operations ---
+	var failed []error
+	if ledger.RoleName != "" {
+		if err := deleteRole(ctx, c, ledger.RoleName, ledger.AttachedPolicyArns, ledger.InlinePolicyNames); err != nil {
+			failed = append(failed, err)
+		} else {
+			ledger.RoleName = ""
 		}
-		fmt.Printf("The entire list of items to remove: %s", itemsToRemove[1])
-		cmdArgs = []string{"delete", resourceToDelete, itemsToRemove[1]}
-		cmd := exec.Command(cmdCommand, cmdArgs...)
-		stdout, err := cmd.Output()
+	}
+	if ledger.KMSPolicyArn != "" {
+		// Detached from the role above, the policy can go now.
+		outcome, err := deleteManagedPolicy(ctx, c, ledger.KMSPolicyArn)
+		report("IAM policy "+ledger.KMSPolicyArn, outcome)
 		if err != nil {
-			fmt.Errorf("failed to get StdoutPipe: %w", err)
+			failed = append(failed, fmt.Errorf("failed to delete IAM policy %s: %w", ledger.KMSPolicyArn, err))
+		} else {
+			ledger.KMSPolicyName, ledger.KMSPolicyArn = "", ""
 		}
-		fmt.Printf("All good: %w", stdout)
-		fmt.Printf("The cmd command is: %s", cmd)
-	} else {
-		initialListArgs = []string{"get", resourceToDelete}
-		itemsToRemove, err := runCommand(initialListCmd, initialListArgs...)
-		for _, item := range itemsToRemove {
-			if strings.Contains(item, clusterId) {
-				backupList = append(backupList, item)
-			}
+	}
+
+	// --- Replication ---
+	if ledger.ReplicationRuleID != "" && ledger.BucketName != "" {
+		// Stop the replication first, the replica must not receive anything while it is deleted.
+		if bucketClient, err := vault.forBucket(ctx, ledger.BucketName); err != nil {
+			fmt.Printf("failed to remove the replication of bucket '%s': %s\n", ledger.BucketName, err)
+		} else if err := bucketClient.DeleteBucketReplication(ctx, ledger.BucketName); err != nil && !isAWSErrorCode(err, errCodeNoSuchBucket) {
+			fmt.Printf("failed to remove the replication of bucket '%s': %s\n", ledger.BucketName, err)
+		} else {
+			fmt.Printf("Replication of bucket '%s' removed.\n", ledger.BucketName)
+		}
+	}
+	if ledger.ReplicationRoleName != "" {
+		if err := deleteRole(ctx, vault, ledger.ReplicationRoleName, nil, []string{replicationPolicyName}); err != nil {
+			failed = append(failed, err)
+		} else {
+			ledger.ReplicationRoleName = ""
 		}
+	}
+
+	// --- S3 Operations ---
+	if ledger.BucketName != "" && !deleteBackupBucket(ctx, vault, ledger.BucketName, purgeWorkers) {
+		ledger.BucketName = ""
+	}
+	if ledger.ReplicaBucketName != "" && !deleteBackupBucket(ctx, vault, ledger.ReplicaBucketName, purgeWorkers) {
+		ledger.ReplicaBucketName = ""
+	}
+
+	// --- KMS Operations ---
+	// A kept bucket keeps its key, its remaining objects cannot be read without it. A key is only
+	// cleared from the ledger once its deletion is scheduled.
+	deleteKey := func(keyArn *string, bucketName string) {
+		if *keyArn == "" {
+			return
+		}
+		if bucketName != "" {
+			report("KMS key "+*keyArn, fmt.Sprintf("kept, it encrypts the remaining objects of bucket %s", bucketName))
+			return
+		}
+		outcome, err := scheduleKeyDeletion(ctx, vault, *keyArn, pendingDays)
+		report("KMS key "+*keyArn, outcome)
 		if err != nil {
-			fmt.Printf("Error getting initial list: %v\n", err)
-		}
-		fmt.Printf("The entire list of backup items to remove: %s", backupList)
-		var cmdArgs []string
-		var cmdCommand = "oc"
-		for _, item := range backupList {
-			cmdArgs = []string{"delete", resourceToDelete, item}
-			cmd := exec.Command(cmdCommand, cmdArgs...)
-			fmt.Println(cmd)
-			stdout, err := cmd.Output()
-			if err != nil {
-				fmt.Errorf("failed to get StdoutPipe: %w", err)
-			}
-			log.Println(string(stdout))
-			fmt.Printf("Resource deleted: %w", item)
+			failed = append(failed, fmt.Errorf("failed to schedule the deletion of KMS key %s: %w", *keyArn, err))
+			return
+		}
+		*keyArn = ""
+	}
+	deleteKey(&ledger.KMSKeyArn, ledger.BucketName)
+	deleteKey(&ledger.ReplicaKMSKeyArn, ledger.ReplicaBucketName)
+
+	// The OIDC provider trusts the issuer of the whole management cluster, the backup roles
+	// of its other hosted clusters rely on it too.
+	if ledger.OIDCProviderCreated {
+		fmt.Printf("Keeping IAM OIDC provider '%s', it is shared by every hosted cluster of management cluster '%s'.\n", ledger.OIDCProviderArn, ledger.MCName)
+	}
+
+	return outcomes, errors.Join(failed...)
+}
 
+// deleteManagedPolicy deletes a customer managed policy and its older versions, which IAM requires
+// to be deleted first, and returns the outcome. The error is set when the policy still exists.
+func deleteManagedPolicy(ctx context.Context, c *awsClient, policyArn string) (string, error) {
+	versions, err := c.ListPolicyVersions(ctx, policyArn)
+	switch {
+	case isAWSErrorCode(err, errCodeNoSuchEntity):
+		return "does not exist", nil
+	case err != nil:
+		return fmt.Sprintf("failed to list its versions: %v", err), err
+	}
+	for _, versionID := range versions {
+		if err := c.DeletePolicyVersion(ctx, policyArn, versionID); err != nil {
+			return fmt.Sprintf("failed to delete version %s: %v", versionID, err), err
 		}
 	}
+	err = c.DeletePolicy(ctx, policyArn)
+	switch {
+	case isAWSErrorCode(err, errCodeNoSuchEntity):
+		return "does not exist", nil
+	case isAWSErrorCode(err, errCodeDeleteConflict):
+		return "kept, it is still attached to other roles, users or groups", err
+	case err != nil:
+		return fmt.Sprintf("failed to delete: %v", err), err
+	}
+	return fmt.Sprintf("deleted, with %d older versions", len(versions)), nil
 }
 
-// cleanupAWSResources performs a series of AWS cleanup operations.
-// It takes the IAM role name, S3 bucket name, and KMS key ARN as input.
-func cleanupAWSResources(clusterId, mcName string) error {
-	// --- Get S3 bucket name ---
-	initialListCmd = "oc"
-	initialListArgs = []string{"get", "bsl", clusterId + "-hourly", "-o", "json"}
+// scheduleKeyDeletion schedules the deletion of a KMS key, in the region of its ARN, after
+// pendingDays and returns the outcome. The error is set when the deletion could not be scheduled
+// and a later teardown should try again; a key already pending deletion needs nothing more.
+func scheduleKeyDeletion(ctx context.Context, c *awsClient, keyArn string, pendingDays int32) (string, error) {
+	if region := regionFromArn(keyArn); region != "" {
+		c = c.withRegion(region)
+	}
+	deletionDate, err := c.ScheduleKeyDeletion(ctx, keyArn, pendingDays)
+	switch {
+	case isAWSErrorCode(err, errCodeKMSNotFound):
+		return "does not exist", nil
+	case isAWSErrorCode(err, errCodeKMSInvalidState):
+		return fmt.Sprintf("not scheduled, it is already pending deletion or cannot be deleted: %v", err), nil
+	case err != nil:
+		return fmt.Sprintf("failed to schedule its deletion: %v", err), err
+	}
+	return fmt.Sprintf("deletion scheduled for %s, it can be cancelled until then", deletionDate.Format(time.RFC3339)), nil
+}
 
-	cmd := exec.Command(initialListCmd, initialListArgs...)
-	fmt.Println(initialListArgs)
-	bucketNameOut, err := cmd.Output()
+// deleteRole deletes a role once IAM allows it: its managed policies are detached, its inline
+// policies deleted and it is removed from its instance profiles. The policies are listed from IAM,
+// so policies added after configure do not block the deletion; the recorded attached and inline
+// policies are included in case a listing fails. It returns an error, with the failures of the
+// earlier steps, when the role still exists afterwards.
+func deleteRole(ctx context.Context, c *awsClient, roleName string, attached, inline []string) error {
+	var failed []error
+	liveAttached, err := c.ListAttachedRolePolicies(ctx, roleName)
+	if isAWSErrorCode(err, errCodeNoSuchEntity) {
+		fmt.Printf("IAM role '%s' does not exist.\n", roleName)
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("failed to list the policies attached to role '%s', detaching the recorded ones: %v\n", roleName, err)
+		failed = append(failed, fmt.Errorf("failed to list the attached policies: %w", err))
+	}
+	liveInline, err := c.ListRolePolicies(ctx, roleName)
 	if err != nil {
-		fmt.Errorf("failed to get StdoutPipe: %w", err)
+		fmt.Printf("failed to list the inline policies of role '%s', deleting the recorded ones: %v\n", roleName, err)
+		failed = append(failed, fmt.Errorf("failed to list the inline policies: %w", err))
+	}
+	profiles, err := c.ListInstanceProfilesForRole(ctx, roleName)
+	if err != nil {
+		fmt.Printf("failed to list the instance profiles of role '%s': %v\n", roleName, err)
+		failed = append(failed, fmt.Errorf("failed to list the instance profiles: %w", err))
 	}
 
-	fmt.Println("S3 bucket name JSON is ", string(bucketNameOut))
-	var bsl BackupStorageLocation
+	// 1. Detach the managed policies
+	for _, policyArn := range unionNames(attached, liveAttached) {
+		if err := c.DetachRolePolicy(ctx, roleName, policyArn); err != nil {
+			if isAWSErrorCode(err, errCodeNoSuchEntity) {
+				fmt.Printf("Role policy %s is not attached.\n", policyArn)
+			} else {
+				fmt.Printf("failed to detach role policy %s: %v\n", policyArn, err)
+				failed = append(failed, fmt.Errorf("failed to detach policy %s: %w", policyArn, err))
+			}
+			continue
+		}
+		fmt.Printf("Role policy %s is detached successfully.\n", policyArn)
+	}
+	// 2. Delete the inline policies
+	for _, policyName := range unionNames(inline, liveInline) {
+		if err := c.DeleteRolePolicy(ctx, roleName, policyName); err != nil {
+			if isAWSErrorCode(err, errCodeNoSuchEntity) {
+				fmt.Printf("Inline policy %s does not exist.\n", policyName)
+			} else {
+				fmt.Printf("failed to delete inline policy %s: %v\n", policyName, err)
+				failed = append(failed, fmt.Errorf("failed to delete inline policy %s: %w", policyName, err))
+			}
+			continue
+		}
+		fmt.Printf("Inline role policy %s is deleted successfully.\n", policyName)
+	}
+	// 3. Remove the role from its instance profiles
+	for _, profile := range profiles {
+		if err := c.RemoveRoleFromInstanceProfile(ctx, profile, roleName); err != nil {
+			fmt.Printf("failed to remove role '%s' from instance profile %s: %v\n", roleName, profile, err)
+			failed = append(failed, fmt.Errorf("failed to remove it from instance profile %s: %w", profile, err))
+			continue
+		}
+		fmt.Printf("Role removed from instance profile %s.\n", profile)
+	}
 
-	// Unmarshal (parse) the JSON string into the struct.
-	s3bucketerr := json.Unmarshal([]byte(bucketNameOut), &bsl)
-	if s3bucketerr != nil {
-		fmt.Println("Error parsing JSON:", err)
-		//return
+	// 4. Delete IAM Role
+	err = c.DeleteRole(ctx, roleName)
+	switch {
+	case isAWSErrorCode(err, errCodeNoSuchEntity):
+		fmt.Printf("IAM role '%s' does not exist.\n", roleName)
+	case err != nil:
+		fmt.Printf("failed to delete IAM role '%s': %v\n", roleName, err)
+		failed = append(failed, err)
+		return fmt.Errorf("failed to delete IAM role %s: %w", roleName, errors.Join(failed...))
+	default:
+		fmt.Printf("Successfully deleted IAM role '%s'.\n", roleName)
 	}
+	return nil
+}
 
-	// Access the nested bucket value and print it.
-	bucketName := bsl.Spec.ObjectStorage.Bucket
-	fmt.Println(bucketName)
+// formatBytes renders a byte count with a binary unit, e.g. 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
 
-	// --- IAM Operations ---
-	var roleNamePrefix = "rosa-hcp-bkp-"
-	// List all policies in the role
-	awsCmd := "aws"
-	rolePolicyListArgs := []string{"iam", "list-attached-role-policies", "--role-name", roleNamePrefix + mcName + "-" + clusterId, "|", "awk", "{print $2}"}
-	rolePolicycmd := exec.Command(awsCmd, rolePolicyListArgs...)
-	rolePolicyListOutput, err := rolePolicycmd.Output()
-	if err != nil {
-		fmt.Printf("Policies are empty or role does not exist: %s", err)
-	}
-	fmt.Printf("Role policies list Output is as follows... %s\n", rolePolicyListOutput)
-
-	for _, item := range rolePolicyListOutput {
-		// 1. Detach IAM Role Policy
-		var detachIAMRolePolicyListArgs = []string{"iam", "detach-role-policy", "--policy-arn", string(item), "--role-name", "rosa-hcp-bkp-" + mcName + "-" + clusterId}
-		detachPolicycmd := exec.Command(awsCmd, detachIAMRolePolicyListArgs...)
-		detachIAMRolePolicyOutput, err := detachPolicycmd.Output()
-		if err != nil {
-			fmt.Printf("Policies are empty or role does not exist: %s", err)
+// unionNames returns the names of recorded followed by the names of listed that are not recorded.
+func unionNames(recorded, listed []string) []string {
+	names := slices.Clone(recorded)
+	for _, name := range listed {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
 		}
-		fmt.Printf("Role policy %s is detached successfully.\n", detachIAMRolePolicyOutput)
 	}
+	return names
+}
 
-	// 2. Delete IAM Role
-	var deleteRoleListArgs = []string{"iam", "delete-role", "--role-name", roleNamePrefix + mcName + "-" + clusterId}
-	deleteRoleCmd := exec.Command(awsCmd, deleteRoleListArgs...)
-	deleteRoleOutput, err := deleteRoleCmd.Output()
+// deleteBackupBucket deletes every object version and delete marker of a bucket with purgeWorkers
+// parallel workers, then the bucket. Object versions under retention or legal hold cannot be
+// deleted: they are reported, and the bucket is kept. It reports whether the bucket was kept:
+// only a bucket that was deleted or does not exist is not, any error keeps it.
+func deleteBackupBucket(ctx context.Context, c *awsClient, bucketName string, purgeWorkers int) (kept bool) {
+	fmt.Printf("Attempting to delete S3 bucket '%s'...\n", bucketName)
+	bucketClient, err := c.forBucket(ctx, bucketName)
+	if isBucketNotFound(err) {
+		fmt.Printf("S3 bucket '%s' does not exist.\n", bucketName)
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Keeping S3 bucket '%s', failed to execute S3 bucket deletion: %s\n", bucketName, err)
+		return true
+	}
+	lockEnabled, err := bucketClient.ObjectLockEnabled(ctx, bucketName)
+	if isBucketNotFound(err) {
+		fmt.Printf("S3 bucket '%s' does not exist.\n", bucketName)
+		return false
+	}
 	if err != nil {
-		fmt.Printf("failed to delete IAM role '%s': %w", roleNamePrefix+mcName+"-"+clusterId, err)
+		// Without the lock configuration the locked objects cannot be told apart.
+		fmt.Printf("Keeping S3 bucket '%s', failed to get its object lock configuration: %s\n", bucketName, err)
+		return true
+	}
+	var lockedObjects []lockedObject
+	if lockEnabled {
+		if lockedObjects, err = bucketClient.LockedObjects(ctx, bucketName); err != nil {
+			fmt.Printf("Keeping S3 bucket '%s', failed to list its locked objects: %s\n", bucketName, err)
+			return true
+		}
+	}
+	keep := map[string]bool{}
+	var lastUntil time.Time
+	for _, obj := range lockedObjects {
+		keep[obj.Key+"\x00"+obj.VersionID] = true
+		if obj.RetainUntil.After(lastUntil) {
+			lastUntil = obj.RetainUntil
+		}
+		hold := ""
+		if obj.LegalHold {
+			hold = ", legal hold"
+		}
+		fmt.Printf("Locked: %s (version %s) %s until %s%s\n", obj.Key, obj.VersionID, obj.Mode, obj.RetainUntil.Format(time.RFC3339), hold)
 	}
-	fmt.Printf("Successfully deleted IAM role '%s'.\n", deleteRoleOutput)
 
-	// 3. Delete S3 Bucket (and its contents first)
-	fmt.Printf("Attempting to delete S3 bucket '%s'...\n", bucketName)
-	s3CmdListArgs := []string{"s3", "rb", "s3://" + bucketName, " --force"}
-	// Execute the s3 command
-	S3Cmd := exec.Command(awsCmd, s3CmdListArgs...)
-	s3CmdOutput, err := S3Cmd.Output()
+	// Purge every object version and delete marker first, a versioned bucket is only empty then
+	fmt.Printf("Deleting all object versions in bucket '%s' with %d workers...\n", bucketName, purgeWorkers)
+	purged, err := bucketClient.PurgeBucket(ctx, bucketName, purgeWorkers, func(key, versionID string) bool {
+		return keep[key+"\x00"+versionID]
+	}, func(progress purgeResult) {
+		fmt.Printf("  %d object versions deleted, %s freed\n", progress.Deleted, formatBytes(progress.Bytes))
+	})
+	fmt.Printf("Deleted %d object versions and delete markers, freed %s.\n", purged.Deleted, formatBytes(purged.Bytes))
+	if isBucketNotFound(err) {
+		fmt.Printf("S3 bucket '%s' does not exist.\n", bucketName)
+		return false
+	}
 	if err != nil {
-		fmt.Printf("failed to execute S3 bucket deletion: %s", err)
+		fmt.Printf("Keeping S3 bucket '%s', it could not be emptied: %s\n", bucketName, err)
+		return true
 	}
-	//List and delete all objects in the bucket first
-	fmt.Printf("Deleting all objects in bucket '%s'...\n", s3CmdOutput)
 
-	fmt.Printf("Successfully deleted S3 bucket '%s'.\n", bucketName)
+	if len(lockedObjects) > 0 {
+		fmt.Printf("Keeping S3 bucket '%s': %d object versions are locked, the last retention ends %s.\n",
+			bucketName, len(lockedObjects), lastUntil.Format(time.RFC3339))
+		return true
+	}
+	err = bucketClient.DeleteBucket(ctx, bucketName)
+	switch {
+	case isAWSErrorCode(err, errCodeNoSuchBucket):
+		fmt.Printf("S3 bucket '%s' does not exist.\n", bucketName)
+	case err != nil:
+		fmt.Printf("Keeping S3 bucket '%s', failed to execute S3 bucket deletion: %s\n", bucketName, err)
+		return true
+	default:
+		fmt.Printf("Successfully deleted S3 bucket '%s'.\n", bucketName)
+	}
+	return false
+}
 
+// printTeardownPlan lists everything teardown is about to delete for the ledger of a cluster.
+func printTeardownPlan(ledger *Ledger, discovered bool, opts teardownOptions) {
+	fmt.Printf("Teardown of cluster %s on management cluster %s deletes:\n", opts.ClusterID, opts.MCName)
+	if ledger.RoleName != "" {
+		fmt.Printf("- IAM role %s, with all its managed and inline policies and instance profiles\n", ledger.RoleName)
+	}
+	if ledger.KMSPolicyArn != "" {
+		fmt.Printf("- IAM policy %s and its older versions\n", ledger.KMSPolicyArn)
+	}
+	if ledger.ReplicationRuleID != "" && ledger.BucketName != "" {
+		fmt.Printf("- replication of S3 bucket %s\n", ledger.BucketName)
+	}
+	if ledger.ReplicationRoleName != "" {
+		fmt.Printf("- IAM role %s\n", ledger.ReplicationRoleName)
+	}
+	vault := ""
+	if ledger.VaultAccountID != "" {
+		vault = " in vault account " + ledger.VaultAccountID
+	}
+	for _, bucket := range []string{ledger.BucketName, ledger.ReplicaBucketName} {
+		if bucket != "" {
+			fmt.Printf("- S3 bucket %s%s, with every object version in it\n", bucket, vault)
+		}
+	}
+	for _, key := range []string{ledger.KMSKeyArn, ledger.ReplicaKMSKeyArn} {
+		if key != "" {
+			fmt.Printf("- KMS key %s, after %d days\n", key, opts.KMSPendingDays)
+		}
+	}
+	fmt.Printf("- schedule %s and its backups, with their data, through Velero DeleteBackupRequests\n", teardownScheduleName(ledger))
+	if ledger.BSLName != "" {
+		fmt.Printf("- backupstoragelocation %s and its backuprepositories\n", ledger.BSLName)
+	}
+	if ledger.SecretName != "" {
+		fmt.Printf("- secret %s\n", ledger.SecretName)
+	}
+	if discovered && opts.DeleteMatching {
+		fmt.Printf("- the other secrets and backuprepositories in %s whose name contains %s\n", veleroNamespace, opts.ClusterID)
+	}
+	if ledger.ReplicaBSLName != "" {
+		fmt.Printf("- backupstoragelocation %s\n", ledger.ReplicaBSLName)
+	}
+	if ledger.OIDCProviderArn != "" {
+		fmt.Printf("The OIDC provider %s is kept, the other clusters of the management cluster use it.\n", ledger.OIDCProviderArn)
+	}
+}
+
+// teardownProtection returns why the resources of a ledger must not be deleted: a bucket or role
+// tagged dr-protect=true, or a cluster that is still ready in OCM. Tags that cannot be read count
+// as protection too. ocm is nil in dry-run mode, the cluster is not looked up then.
+func teardownProtection(ctx context.Context, c, vault *awsClient, ocm *ocmClient, ledger *Ledger) []string {
+	var reasons []string
+	protected := func(kind, name string, tags map[string]string, err error) {
+		switch {
+		case err != nil:
+			reasons = append(reasons, fmt.Sprintf("the tags of %s %s could not be read: %s", kind, name, err))
+		case strings.EqualFold(tags[protectTagKey], "true"):
+			reasons = append(reasons, fmt.Sprintf("%s %s is tagged %s=true", kind, name, protectTagKey))
+		}
+	}
+	for _, role := range []struct {
+		client *awsClient
+		name   string
+	}{{c, ledger.RoleName}, {vault, ledger.ReplicationRoleName}} {
+		if role.name == "" {
+			continue
+		}
+		tags, err := role.client.RoleTags(ctx, role.name)
+		if isAWSErrorCode(err, errCodeNoSuchEntity) {
+			continue
+		}
+		protected("IAM role", role.name, tags, err)
+	}
+	for _, bucket := range []string{ledger.BucketName, ledger.ReplicaBucketName} {
+		if bucket == "" {
+			continue
+		}
+		bucketClient, err := vault.forBucket(ctx, bucket)
+		var tags map[string]string
+		if err == nil {
+			tags, err = bucketClient.BucketTags(ctx, bucket)
+		}
+		if isBucketNotFound(err) {
+			continue
+		}
+		protected("S3 bucket", bucket, tags, err)
+	}
+	if ocm != nil {
+		cluster, err := ocm.FindCluster(ctx, ledger.ClusterID)
+		switch {
+		case err != nil:
+			reasons = append(reasons, fmt.Sprintf("the state of cluster %s could not be read from OCM: %s", ledger.ClusterID, err))
+		case cluster != nil && cluster.State == "ready":
+			reasons = append(reasons, fmt.Sprintf("cluster %s (%s) is still ready in OCM", ledger.ClusterID, cluster.Name))
+		}
+	}
+	return reasons
+}
+
+// teardownScheduleName returns the name of the Velero schedule of a ledger's cluster.
+func teardownScheduleName(ledger *Ledger) string {
+	if ledger.ScheduleName != "" {
+		return ledger.ScheduleName
+	}
+	return ledger.ClusterID + "-hourly"
+}
+
+// confirmTeardown asks for the cluster ID on in and returns an error unless it is typed back.
+func confirmTeardown(clusterID string, in io.Reader) error {
+	fmt.Printf("Type the cluster ID %s to delete these resources: ", clusterID)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("teardown not confirmed: %w; pass --yes to run without the prompt", err)
+	}
+	if strings.TrimSpace(answer) != clusterID {
+		return fmt.Errorf("teardown cancelled: %q is not the cluster ID %s", strings.TrimSpace(answer), clusterID)
+	}
 	return nil
 }
 
-func main() {
-	// os.Args[1] -> clusterId
-	// os.Args[2] -> mc name
+// teardownOptions holds the flags accepted by the teardown subcommand.
+type teardownOptions struct {
+	ClusterID string
+	MCName    string
+	AWSRegion string
+	// KMSPendingDays is the waiting period before the KMS keys are deleted.
+	KMSPendingDays int
+	// PurgeWorkers is the number of parallel DeleteObjects calls that empty a bucket.
+	PurgeWorkers int
+	// BackupDeletionTimeout is how long Velero gets to process the DeleteBackupRequests.
+	BackupDeletionTimeout time.Duration
+	// Yes skips the prompt for the cluster ID, Force deletes protected resources and ready clusters.
+	Yes   bool
+	Force bool
+	// DeleteMatching deletes the secrets and backuprepositories whose name contains the cluster ID
+	// when there is no ledger to name them.
+	DeleteMatching bool
 
-	var clusterId = os.Args[1]
-	var mcName = os.Args[2]
+	Exec   executorOptions
+	State  stateOptions
+	AWS    awsOptions
+	Vault  vaultOptions
+	OCM    ocmOptions
+	MCKube kubeOptions
+}
 
-	fmt.Println("------Delete Openshift resources-------")
-	cleanupAWSResources(clusterId, mcName)
+// runTeardown parses the teardown flags and deletes the AWS and Openshift backup resources of a cluster.
+func runTeardown(args []string) error {
+	var opts teardownOptions
+	fs := flag.NewFlagSet("teardown", flag.ExitOnError)
+	fs.StringVar(&opts.ClusterID, "cluster-id", "", "ROSA HCP cluster ID whose backup resources are deleted")
+	fs.StringVar(&opts.MCName, "mc-name", "", "hive's management cluster name, e.g. hs-mc-n1j3kghkg")
+	fs.StringVar(&opts.AWSRegion, "region", defaultAWSRegion, "AWS region used for the regional API calls, buckets are always reached in their own region")
+	fs.IntVar(&opts.KMSPendingDays, "kms-pending-days", maxKeyPendingDays, "days before the KMS keys are deleted, between 7 and 30; the deletion can be cancelled until then")
+	fs.IntVar(&opts.PurgeWorkers, "purge-workers", 8, "parallel DeleteObjects calls, of up to 1000 object versions each, used to empty a bucket")
+	fs.DurationVar(&opts.BackupDeletionTimeout, "backup-deletion-timeout", 10*time.Minute, "how long to wait for Velero to delete the backups of the cluster and their data")
+	fs.BoolVar(&opts.Yes, "yes", false, "delete without prompting for the cluster ID")
+	fs.BoolVar(&opts.DeleteMatching, "delete-matching", false, "without a state ledger, also delete the secrets and backuprepositories in "+veleroNamespace+" whose name contains the cluster ID")
+	fs.BoolVar(&opts.Force, "force", false, "delete even when a bucket or role is tagged "+protectTagKey+"=true or the cluster is still ready in OCM")
+	opts.Exec.register(fs)
+	opts.State.register(fs)
+	opts.AWS.register(fs)
+	opts.Vault.register(fs)
+	opts.OCM.register(fs)
+	opts.MCKube.register(fs, "mc", targetMC)
+	fs.Parse(args)
+	if err := requireFlags(fs, "cluster-id", "mc-name"); err != nil {
+		return err
+	}
+	if opts.KMSPendingDays < minKeyPendingDays || opts.KMSPendingDays > maxKeyPendingDays {
+		return fmt.Errorf("invalid --kms-pending-days %d, expected %d to %d days", opts.KMSPendingDays, minKeyPendingDays, maxKeyPendingDays)
+	}
+	if opts.PurgeWorkers < 1 {
+		return fmt.Errorf("invalid --purge-workers %d, expected at least 1", opts.PurgeWorkers)
+	}
+	if err := opts.Exec.install(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	awsc, err := newAWSClient(ctx, opts.AWSRegion, opts.AWS, opts.Exec)
+	if err != nil {
+		return err
+	}
+	account, callerArn, err := awsc.PrintIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	vault, err := openVault(ctx, awsc, account, callerArn, opts.Vault, opts.Exec)
+	if err != nil {
+		return err
+	}
+	kube, err := newKubeClient(opts.MCKube, opts.Exec)
+	if err != nil {
+		return err
+	}
+	// The BSL of the cluster names the bucket to delete, so it must be read from the right cluster.
+	var ocm *ocmClient
+	if !opts.Exec.DryRun {
+		ocm, err = newOCMClient(opts.OCM, opts.Exec)
+		if err != nil {
+			return err
+		}
+		mc, err := ocm.FindManagementCluster(ctx, opts.MCName, "")
+		if err != nil {
+			return fmt.Errorf("preflight failed: %w", err)
+		}
+		if err := preflightCluster(ctx, ocm, kube, targetMC, mc); err != nil {
+			return fmt.Errorf("preflight failed: %w", err)
+		}
+	}
+	store, err := opts.State.open(ctx, opts.Exec.DryRun, awsc, kube)
+	if err != nil {
+		return err
+	}
+
+	ledger, err := store.Load(opts.ClusterID)
+	discovered := errors.Is(err, errLedgerNotFound)
+	if discovered {
+		fmt.Printf("Warning: no state ledger found for cluster %s, discovering its resources instead.\n", opts.ClusterID)
+		if ledger, err = discoverLedger(ctx, awsc, kube, opts.ClusterID, opts.MCName); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if err := checkVaultAccount(ledger, vault); err != nil {
+		return err
+	}
+
+	fmt.Println("------Deletion plan-------")
+	printTeardownPlan(ledger, discovered, opts)
+	if reasons := teardownProtection(ctx, awsc, vault.awsClient, ocm, ledger); len(reasons) > 0 {
+		for _, reason := range reasons {
+			fmt.Printf("Protected: %s\n", reason)
+		}
+		if !opts.Force {
+			return fmt.Errorf("refusing to tear down cluster %s, pass --force to delete it anyway", opts.ClusterID)
+		}
+		fmt.Println("Deleting anyway, --force is set.")
+	}
+	if !opts.Exec.DryRun && !opts.Yes {
+		if err := confirmTeardown(opts.ClusterID, os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	// Velero deletes the backup data with the bucket, role and BSL, so they must all still exist.
+	// The schedule goes first, it must not start a backup while its backups are deleted.
+	fmt.Println("------Delete Velero backups-------")
+	deleteResource(ctx, kube, "schedule", teardownScheduleName(ledger))
+	backupOutcomes := deleteScheduleBackups(ctx, kube, teardownScheduleName(ledger), opts.BackupDeletionTimeout)
+
+	fmt.Println("------Delete AWS resources-------")
+	outcomes, cleanupErr := cleanupAWSResources(ctx, awsc, vault.awsClient, ledger, int32(opts.KMSPendingDays), opts.PurgeWorkers)
 
 	fmt.Println("------Delete Openshift resources-------")
-	deleteResource("bsl", clusterId)
-	deleteResource("schedule", clusterId)
-	deleteResource("backup", clusterId)
-	deleteResource("secret", clusterId)
-	deleteResource("backuprepository", clusterId)
+	deleteResource(ctx, kube, "bsl", ledger.BSLName)
+	deleteResource(ctx, kube, "secret", ledger.SecretName)
+	deleteBackupRepositories(ctx, kube, ledger.BSLName)
+	if discovered {
+		deleteMatchingResources(ctx, kube, opts.ClusterID, opts.DeleteMatching)
+	}
+	if ledger.ReplicaBSLName != "" {
+		if err := kube.Delete(ctx, bslGVR, veleroNamespace, ledger.ReplicaBSLName); err != nil {
+			fmt.Printf("failed to delete backupstoragelocation %s: %s\n", ledger.ReplicaBSLName, err)
+		} else {
+			fmt.Printf("backupstoragelocation %s deleted\n", ledger.ReplicaBSLName)
+		}
+	}
+
+	fmt.Println("------Velero backups-------")
+	if len(backupOutcomes) == 0 {
+		fmt.Println("The schedule of the cluster has no backups.")
+	}
+	for _, o := range backupOutcomes {
+		fmt.Printf("- %s: %s\n", o.resource, o.outcome)
+	}
+
+	fmt.Println("------KMS keys and IAM policies-------")
+	if len(outcomes) == 0 {
+		fmt.Println("No KMS key or customer managed policy is recorded for the cluster.")
+	}
+	for _, o := range outcomes {
+		fmt.Printf("- %s: %s\n", o.resource, o.outcome)
+	}
+
+	// Only the kept roles, policies, buckets and keys are left, a later teardown deletes them once the
+	// retention has ended or what blocked their deletion is fixed.
+	var kept []string
+	for _, role := range []string{ledger.RoleName, ledger.ReplicationRoleName} {
+		if role != "" {
+			kept = append(kept, "role '"+role+"'")
+		}
+	}
+	if ledger.KMSPolicyArn != "" {
+		kept = append(kept, "policy '"+ledger.KMSPolicyArn+"'")
+	}
+	for _, bucket := range []string{ledger.BucketName, ledger.ReplicaBucketName} {
+		if bucket != "" {
+			kept = append(kept, "bucket '"+bucket+"'")
+		}
+	}
+	for _, key := range []string{ledger.KMSKeyArn, ledger.ReplicaKMSKeyArn} {
+		if key != "" {
+			kept = append(kept, "KMS key '"+key+"'")
+		}
+	}
+	if len(kept) == 0 {
+		return store.Delete(opts.ClusterID)
+	}
+	fmt.Printf("Keeping the state ledger of cluster %s for %s, rerun teardown once they can be deleted.\n", opts.ClusterID, strings.Join(kept, " and "))
+	reduced := &Ledger{
+		ClusterID:           ledger.ClusterID,
+		MCName:              ledger.MCName,
+		Region:              ledger.Region,
+		VaultAccountID:      ledger.VaultAccountID,
+		BucketName:          ledger.BucketName,
+		ObjectLockMode:      ledger.ObjectLockMode,
+		ObjectLockDays:      ledger.ObjectLockDays,
+		KMSKeyArn:           ledger.KMSKeyArn,
+		ReplicaRegion:       ledger.ReplicaRegion,
+		ReplicaBucketName:   ledger.ReplicaBucketName,
+		ReplicaKMSKeyArn:    ledger.ReplicaKMSKeyArn,
+		ReplicationRoleName: ledger.ReplicationRoleName,
+		KMSPolicyName:       ledger.KMSPolicyName,
+		KMSPolicyArn:        ledger.KMSPolicyArn,
+	}
+	if ledger.RoleName != "" {
+		reduced.RoleName = ledger.RoleName
+		reduced.RoleArn = ledger.RoleArn
+		reduced.AttachedPolicyArns = ledger.AttachedPolicyArns
+		reduced.InlinePolicyNames = ledger.InlinePolicyNames
+	}
+	if err := saveLedger(store, reduced); err != nil {
+		return err
+	}
+	// A kept bucket is expected while its objects are locked, a role, policy or key that could not
+	// be deleted is not.
+	return cleanupErr
 }
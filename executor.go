@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Executor runs an external command (rosa, aws, ocm, oc, jq, ...) and returns its stdout and stderr.
+// Every command issued by drtest goes through the package level executor.
+type Executor interface {
+	Run(name string, args ...string) (string, string, error)
+}
+
+// executor is the Executor used by runCommand. It is swapped for a dryRunExecutor by --dry-run.
+var executor Executor = execExecutor{}
+
+// execExecutor runs commands on the local machine with os/exec.
+type execExecutor struct{}
+
+// Run executes the command and returns its stdout and stderr.
+// It also prints the command being executed for clarity.
+func (execExecutor) Run(name string, args ...string) (string, string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// Print the command being executed
+	fmt.Printf("Executing command: %s\n", formatCommand(name, args))
+
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// dryRunExecutor prints the command plan instead of running anything.
+// Each command is numbered and returns the placeholder "<output-N>" as its stdout,
+// so later commands in the plan show which earlier output they consume.
+type dryRunExecutor struct {
+	count int
+}
+
+// Run prints the command and returns a placeholder for its output.
+func (d *dryRunExecutor) Run(name string, args ...string) (string, string, error) {
+	return d.plan(name, args...), "", nil
+}
+
+// plan numbers and prints one entry of the plan and returns its "<output-N>" placeholder.
+// The API clients use it too, so commands and API calls share one numbered plan.
+func (d *dryRunExecutor) plan(name string, args ...string) string {
+	d.count++
+	fmt.Printf("[dry-run] #%d %s\n", d.count, formatCommand(name, args))
+	return fmt.Sprintf("<output-%d>", d.count)
+}
+
+// formatCommand renders a command line the way it would be typed in a shell,
+// single-quoting the arguments that contain whitespace or shell metacharacters.
+func formatCommand(name string, args []string) string {
+	parts := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'$|&;<>(){}[]*?`\\") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+)
+
+// hardenOptions holds the flags accepted by the harden subcommand.
+type hardenOptions struct {
+	ClusterID string
+	MCName    string
+	Exec      executorOptions
+	State     stateOptions
+	AWS       awsOptions
+	Vault     vaultOptions
+	OCM       ocmOptions
+	MCKube    kubeOptions
+}
+
+// runHarden brings the backup resources of a cluster configured by an earlier version up to date.
+// The backup role is migrated off AmazonS3FullAccess: it gets the inline policy scoped to its bucket
+// first, so Velero never loses access, and the managed policy is detached afterwards. The bucket
+// gets the hardening baseline.
+func runHarden(args []string) error {
+	var opts hardenOptions
+	fs := flag.NewFlagSet("harden", flag.ExitOnError)
+	fs.StringVar(&opts.ClusterID, "cluster-id", "", "ROSA HCP cluster ID whose backup role is hardened")
+	fs.StringVar(&opts.MCName, "mc-name", "", "hive's management cluster name, e.g. hs-mc-n1j3kghkg")
+	opts.Exec.register(fs)
+	opts.State.register(fs)
+	opts.AWS.register(fs)
+	opts.Vault.register(fs)
+	opts.OCM.register(fs)
+	opts.MCKube.register(fs, "mc", targetMC)
+	fs.Parse(args)
+	if err := requireFlags(fs, "cluster-id", "mc-name"); err != nil {
+		return err
+	}
+	if err := opts.Exec.install(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	awsc, err := newAWSClient(ctx, defaultAWSRegion, opts.AWS, opts.Exec)
+	if err != nil {
+		return err
+	}
+	account, callerArn, err := awsc.PrintIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	vault, err := openVault(ctx, awsc, account, callerArn, opts.Vault, opts.Exec)
+	if err != nil {
+		return err
+	}
+	kube, err := newKubeClient(opts.MCKube, opts.Exec)
+	if err != nil {
+		return err
+	}
+	// The ledger and BSL name the role and bucket to change, so they must be read from the right cluster.
+	if !opts.Exec.DryRun {
+		ocm, err := newOCMClient(opts.OCM, opts.Exec)
+		if err != nil {
+			return err
+		}
+		mc, err := ocm.FindManagementCluster(ctx, opts.MCName, "")
+		if err != nil {
+			return fmt.Errorf("preflight failed: %w", err)
+		}
+		if err := preflightCluster(ctx, ocm, kube, targetMC, mc); err != nil {
+			return fmt.Errorf("preflight failed: %w", err)
+		}
+	}
+	store, err := opts.State.open(ctx, opts.Exec.DryRun, awsc, kube)
+	if err != nil {
+		return err
+	}
+
+	ledger, err := store.Load(opts.ClusterID)
+	recorded := err == nil
+	if errors.Is(err, errLedgerNotFound) {
+		fmt.Printf("Warning: no state ledger found for cluster %s, discovering its resources instead.\n", opts.ClusterID)
+		if ledger, err = discoverLedger(ctx, awsc, kube, opts.ClusterID, opts.MCName); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if ledger.RoleName == "" || ledger.BucketName == "" {
+		return fmt.Errorf("cannot harden cluster %s: its backup role or bucket is unknown", opts.ClusterID)
+	}
+	if err := checkVaultAccount(ledger, vault); err != nil {
+		return err
+	}
+
+	fmt.Println("------Harden the backup role-------")
+	if err := putBucketAccessPolicy(ctx, awsc, ledger.RoleName, ledger.BucketName); err != nil {
+		return err
+	}
+	ledger.addInlinePolicy(bucketAccessPolicyName)
+
+	attached, err := awsc.ListAttachedRolePolicies(ctx, ledger.RoleName)
+	if err != nil {
+		return fmt.Errorf("failed to list the policies attached to role %s: %w", ledger.RoleName, err)
+	}
+	if slices.Contains(attached, s3FullAccessPolicyArn) || awsc.dryRun != nil {
+		if err := awsc.DetachRolePolicy(ctx, ledger.RoleName, s3FullAccessPolicyArn); err != nil {
+			return fmt.Errorf("failed to detach AmazonS3FullAccess from role %s: %w", ledger.RoleName, err)
+		}
+		fmt.Printf("AmazonS3FullAccess detached from role '%s'.\n", ledger.RoleName)
+	} else {
+		fmt.Printf("Role '%s' has no AmazonS3FullAccess attached.\n", ledger.RoleName)
+	}
+	ledger.removeAttachedPolicy(s3FullAccessPolicyArn)
+
+	fmt.Println("------Harden the backup bucket-------")
+	bucketClient, err := vault.forBucket(ctx, ledger.BucketName)
+	if err != nil {
+		return err
+	}
+	if err := applyBucketBaseline(ctx, bucketClient, ledger.BucketName); err != nil {
+		return err
+	}
+	if ledger.VaultAccountID != "" {
+		// The bucket policy is what grants the role of the other account access.
+		if err := grantVaultBucketAccess(ctx, bucketClient, ledger.BucketName, ledger.RoleArn, false); err != nil {
+			return err
+		}
+	}
+
+	if !recorded {
+		// A discovered ledger is incomplete, saving it would make teardown trust it.
+		return nil
+	}
+	return saveLedger(store, ledger)
+}
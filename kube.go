@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeFieldManager owns the fields drtest applies with server-side apply.
+const kubeFieldManager = "drtest"
+
+// Resources of the Kubernetes API drtest reads and writes.
+var (
+	secretGVR           = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	configMapGVR        = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	bslGVR              = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "backupstoragelocations"}
+	scheduleGVR         = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "schedules"}
+	backupGVR           = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "backups"}
+	backupRepositoryGVR = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "backuprepositories"}
+	deleteRequestGVR    = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "deletebackuprequests"}
+	managedClusterGVR   = schema.GroupVersionResource{Group: "cluster.open-cluster-management.io", Version: "v1", Resource: "managedclusters"}
+)
+
+// kindResources maps the kinds drtest applies to their resources.
+var kindResources = map[schema.GroupVersionKind]schema.GroupVersionResource{
+	{Version: "v1", Kind: "Secret"}:                                    secretGVR,
+	{Version: "v1", Kind: "ConfigMap"}:                                 configMapGVR,
+	{Group: "velero.io", Version: "v1", Kind: "BackupStorageLocation"}: bslGVR,
+	{Group: "velero.io", Version: "v1", Kind: "Schedule"}:              scheduleGVR,
+	{Group: "velero.io", Version: "v1", Kind: "DeleteBackupRequest"}:   deleteRequestGVR,
+}
+
+// clusterTarget names the cluster a step talks to.
+type clusterTarget string
+
+const (
+	// targetNone marks steps that only call AWS or OCM.
+	targetNone clusterTarget = ""
+	// targetMC is the management cluster running Velero and the hosted control planes.
+	targetMC clusterTarget = "management cluster"
+	// targetSC is the service cluster the management cluster is registered with.
+	targetSC clusterTarget = "service cluster"
+)
+
+// kubeOptions holds the flags that select the kubeconfig and context of one cluster.
+type kubeOptions struct {
+	Kubeconfig string
+	Context    string
+}
+
+// register adds the --<prefix>-kubeconfig and --<prefix>-context flags of a cluster to a subcommand's flag set.
+func (o *kubeOptions) register(fs *flag.FlagSet, prefix string, target clusterTarget) {
+	fs.StringVar(&o.Kubeconfig, prefix+"-kubeconfig", "", fmt.Sprintf("kubeconfig of the %s, defaults to $KUBECONFIG or ~/.kube/config", target))
+	fs.StringVar(&o.Context, prefix+"-context", "", fmt.Sprintf("kubeconfig context of the %s, defaults to the current context", target))
+}
+
+// set reports whether a kubeconfig or context was given for the cluster.
+func (o kubeOptions) set() bool {
+	return o.Kubeconfig != "" || o.Context != ""
+}
+
+// restConfig loads the client configuration of the selected kubeconfig and context.
+func (o kubeOptions) restConfig() (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.Context}
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	return cfg, nil
+}
+
+// kubeClient reads and writes the Velero objects through the dynamic client.
+// It works on any dynamic.Interface, so the fake dynamic client can stand in for a cluster.
+// In dry-run mode every call is printed as part of the plan and nothing is sent.
+type kubeClient struct {
+	dyn    dynamic.Interface
+	host   string
+	dryRun *dryRunExecutor
+}
+
+// newKubeClient builds a client for the cluster selected by the flags.
+// API calls go through httpTransport, so they are recorded and replayed with the rest of the run.
+func newKubeClient(opts kubeOptions, execOpts executorOptions) (*kubeClient, error) {
+	if dryRun, ok := executor.(*dryRunExecutor); ok {
+		return &kubeClient{dryRun: dryRun}, nil
+	}
+	cfg, err := opts.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	if execOpts.Replay != "" {
+		// Replayed requests are never sent, the kubeconfig only provides the API server URL.
+		cfg = rest.AnonymousClientConfig(cfg)
+	}
+	if execOpts.Record != "" || execOpts.Replay != "" {
+		cfg.Wrap(wrapKubeTransport)
+	}
+	dyn, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return &kubeClient{dyn: dyn, host: cfg.Host}, nil
+}
+
+// wrapKubeTransport routes the traffic of a Kubernetes client through the recording or
+// replaying httpTransport. The recorder sits below client-go's authentication, so tokens
+// never end up in a transcript.
+func wrapKubeTransport(rt http.RoundTripper) http.RoundTripper {
+	switch t := httpTransport.(type) {
+	case *recordingTransport:
+		return &recordingTransport{inner: rt, recorder: t.recorder}
+	case *replayTransport:
+		return t
+	}
+	return rt
+}
+
+// Apply creates or updates an object with server-side apply.
+func (k *kubeClient) Apply(ctx context.Context, obj *unstructured.Unstructured) error {
+	gvr, ok := kindResources[obj.GroupVersionKind()]
+	if !ok {
+		return fmt.Errorf("cannot apply %s %s: unknown kind", obj.GroupVersionKind(), obj.GetName())
+	}
+	if k.dryRun != nil {
+		k.plan("apply", gvr, obj.GetNamespace(), obj.GetName())
+		return nil
+	}
+	_, err := k.dyn.Resource(gvr).Namespace(obj.GetNamespace()).Apply(ctx, obj.GetName(), obj,
+		metav1.ApplyOptions{FieldManager: kubeFieldManager, Force: true})
+	return err
+}
+
+// Create creates a new object and returns it as stored, so the name the API server generated for
+// an object with a generateName is known.
+func (k *kubeClient) Create(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	gvr, ok := kindResources[obj.GroupVersionKind()]
+	if !ok {
+		return nil, fmt.Errorf("cannot create %s %s: unknown kind", obj.GroupVersionKind(), obj.GetGenerateName())
+	}
+	if k.dryRun != nil {
+		created := obj.DeepCopy()
+		if created.GetName() == "" {
+			created.SetName(created.GetGenerateName() + "<generated>")
+		}
+		k.plan("create", gvr, obj.GetNamespace(), created.GetName())
+		return created, nil
+	}
+	return k.dyn.Resource(gvr).Namespace(obj.GetNamespace()).Create(ctx, obj, metav1.CreateOptions{FieldManager: kubeFieldManager})
+}
+
+// ApplyManifest applies every object of a multi-document YAML manifest.
+func (k *kubeClient) ApplyManifest(ctx context.Context, manifest string) error {
+	objs, err := decodeManifest(manifest)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		if err := k.Apply(ctx, obj); err != nil {
+			return fmt.Errorf("failed to apply %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		fmt.Printf("Applied %s %s/%s\n", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+	}
+	return nil
+}
+
+// Get returns an object. A missing object is reported with an error matching isKubeNotFound.
+func (k *kubeClient) Get(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	if k.dryRun != nil {
+		k.plan("get", gvr, namespace, name)
+		obj := &unstructured.Unstructured{Object: map[string]any{}}
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		return obj, nil
+	}
+	return k.dyn.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// List returns the objects of a resource in a namespace matching the label selector.
+func (k *kubeClient) List(ctx context.Context, gvr schema.GroupVersionResource, namespace, selector string) ([]unstructured.Unstructured, error) {
+	if k.dryRun != nil {
+		if selector != "" {
+			k.plan("list", gvr, namespace, "-l", selector)
+		} else {
+			k.plan("list", gvr, namespace)
+		}
+		return nil, nil
+	}
+	list, err := k.dyn.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Delete deletes an object. A missing object is reported with an error matching isKubeNotFound.
+func (k *kubeClient) Delete(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) error {
+	if k.dryRun != nil {
+		k.plan("delete", gvr, namespace, name)
+		return nil
+	}
+	return k.dyn.Resource(gvr).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+// plan prints a planned call in dry-run mode.
+func (k *kubeClient) plan(verb string, gvr schema.GroupVersionResource, namespace string, args ...string) {
+	resource := gvr.Resource
+	if gvr.Group != "" {
+		resource += "." + gvr.Group
+	}
+	args = append([]string{verb, resource}, args...)
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	k.dryRun.plan("kube", args...)
+}
+
+// decodeManifest splits a multi-document YAML manifest into objects.
+func decodeManifest(manifest string) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader([]byte(manifest)), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if errors.Is(err, io.EOF) {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if len(obj.Object) > 0 {
+			objs = append(objs, obj)
+		}
+	}
+}
+
+// preflightCluster verifies that the client talks to the API server OCM reports for the target
+// cluster of the management cluster mc, so resources are never applied to the wrong cluster.
+func preflightCluster(ctx context.Context, ocm *ocmClient, kube *kubeClient, target clusterTarget, mc *ocmManagementCluster) error {
+	href := mc.ClusterManagementReference.Href
+	name := mc.Name
+	if target == targetSC {
+		sc, err := ocm.GetServiceCluster(ctx, mc.Parent.Href)
+		if err != nil {
+			return fmt.Errorf("failed to find the service cluster of %s: %w", mc.Name, err)
+		}
+		href, name = sc.ClusterManagementReference.Href, sc.Name
+	}
+	cluster, err := ocm.GetCluster(ctx, href)
+	if err != nil {
+		return err
+	}
+	if !sameAPIServer(kube.host, cluster.API.URL) {
+		return fmt.Errorf("the selected kubeconfig talks to %s, but the %s %s is served at %s; pass the right kubeconfig or context",
+			kube.host, target, name, cluster.API.URL)
+	}
+	fmt.Printf("Preflight: %s %s is served at %s\n", target, name, kube.host)
+	return nil
+}
+
+// sameAPIServer reports whether two API server URLs point at the same host and port.
+func sameAPIServer(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil || ua.Host == "" {
+		return false
+	}
+	port := func(u *url.URL) string {
+		if p := u.Port(); p != "" {
+			return p
+		}
+		if u.Scheme == "http" {
+			return "80"
+		}
+		return "443"
+	}
+	return strings.EqualFold(ua.Hostname(), ub.Hostname()) && port(ua) == port(ub)
+}
+
+// nestedString returns a string field of an object, or "" when it is not set.
+func nestedString(obj *unstructured.Unstructured, fields ...string) string {
+	value, _, _ := unstructured.NestedString(obj.Object, fields...)
+	return value
+}
+
+// isKubeNotFound reports whether a Kubernetes API error means the object does not exist.
+func isKubeNotFound(err error) bool {
+	return apierrors.IsNotFound(err)
+}
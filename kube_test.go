@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testBackupManifest = `apiVersion: v1
+kind: Secret
+metadata:
+  name: abc-backup-role
+  namespace: openshift-adp
+type: Opaque
+data:
+  credentials: W2RlZmF1bHRdCg==
+---
+apiVersion: velero.io/v1
+kind: BackupStorageLocation
+metadata:
+  name: abc-hourly
+  namespace: openshift-adp
+spec:
+  provider: aws
+  objectStorage:
+    bucket: drtest-abc
+---
+apiVersion: velero.io/v1
+kind: Schedule
+metadata:
+  name: abc-hourly
+  namespace: openshift-adp
+spec:
+  schedule: "30 * * * *"
+`
+
+// newFakeKubeClient returns a kubeClient backed by client-go's fake dynamic client.
+// The fake tracker cannot merge an apply patch into an unstructured object, so a reactor
+// creates or replaces the object, which is what a forced apply of a complete object does.
+func newFakeKubeClient(t *testing.T) *kubeClient {
+	t.Helper()
+	listKinds := map[schema.GroupVersionResource]string{}
+	for gvk, gvr := range kindResources {
+		listKinds[gvr] = gvk.Kind + "List"
+	}
+	fake := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	fake.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		tracker := fake.Tracker()
+		_, err := tracker.Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		switch {
+		case isKubeNotFound(err):
+			err = tracker.Create(patch.GetResource(), obj, patch.GetNamespace())
+		case err == nil:
+			err = tracker.Update(patch.GetResource(), obj, patch.GetNamespace())
+		}
+		return true, obj, err
+	})
+	return &kubeClient{dyn: fake}
+}
+
+func TestKubeClientApplyAndDelete(t *testing.T) {
+	ctx := context.Background()
+	kube := newFakeKubeClient(t)
+	if err := kube.ApplyManifest(ctx, testBackupManifest); err != nil {
+		t.Fatalf("ApplyManifest: %v", err)
+	}
+
+	tests := []struct {
+		gvr   schema.GroupVersionResource
+		name  string
+		field []string
+		want  string
+	}{
+		{gvr: secretGVR, name: "abc-backup-role", field: []string{"data", "credentials"}, want: "W2RlZmF1bHRdCg=="},
+		{gvr: bslGVR, name: "abc-hourly", field: []string{"spec", "objectStorage", "bucket"}, want: "drtest-abc"},
+		{gvr: scheduleGVR, name: "abc-hourly", field: []string{"spec", "schedule"}, want: "30 * * * *"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gvr.Resource, func(t *testing.T) {
+			obj, err := kube.Get(ctx, tt.gvr, "openshift-adp", tt.name)
+			if err != nil {
+				t.Fatalf("Get after apply: %v", err)
+			}
+			if got := nestedString(obj, tt.field...); got != tt.want {
+				t.Errorf("%v = %q, want %q", tt.field, got, tt.want)
+			}
+
+			// Applying the same object again updates it in place.
+			obj.SetLabels(map[string]string{"reapplied": "true"})
+			if err := kube.Apply(ctx, obj); err != nil {
+				t.Fatalf("re-Apply: %v", err)
+			}
+			obj, err = kube.Get(ctx, tt.gvr, "openshift-adp", tt.name)
+			if err != nil {
+				t.Fatalf("Get after re-apply: %v", err)
+			}
+			if obj.GetLabels()["reapplied"] != "true" {
+				t.Errorf("re-apply did not update the object: labels %v", obj.GetLabels())
+			}
+
+			if err := kube.Delete(ctx, tt.gvr, "openshift-adp", tt.name); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := kube.Get(ctx, tt.gvr, "openshift-adp", tt.name); !isKubeNotFound(err) {
+				t.Errorf("Get after delete: got %v, want not found", err)
+			}
+			if err := kube.Delete(ctx, tt.gvr, "openshift-adp", tt.name); !isKubeNotFound(err) {
+				t.Errorf("second Delete: got %v, want not found", err)
+			}
+		})
+	}
+}
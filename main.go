@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// subcommands maps every drtest subcommand to the function that runs it.
+var subcommands = map[string]func(args []string) error{
+	"configure": runConfigure,
+	"teardown":  runTeardown,
+	"status":    runStatus,
+	"validate":  runValidate,
+	"harden":    runHarden,
+	"audit":     runAudit,
+}
+
+// usage prints the list of subcommands supported by drtest.
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: drtest <subcommand> [flags]
+
+Subcommands:
+  configure   create the S3 bucket, IAM role, KMS key and Velero resources for a cluster
+  teardown    delete the AWS and Openshift backup resources of a cluster
+  status      show the Velero schedule, storage location and backups of a cluster
+  validate    check that the backup schedule of a cluster exists
+  harden      scope the backup role of a cluster to its bucket and apply the bucket hardening baseline
+  audit       check every backup bucket of the account against the hardening baseline
+
+Run 'drtest <subcommand> -h' to list the flags of a subcommand.
+`)
+}
+
+// requireFlags returns an error naming every listed flag that was left empty.
+func requireFlags(fs *flag.FlagSet, names ...string) error {
+	var missing []string
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || f.Value.String() == "" {
+			missing = append(missing, "--"+name)
+		}
+	}
+	if len(missing) > 0 {
+		fs.Usage()
+		return fmt.Errorf("missing required flags: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// stringList is a flag that can be repeated or given a comma separated list, e.g. --kms-admin a --kms-admin b,c.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if name == "-h" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+	run, ok := subcommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown subcommand %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		log.Fatalf("%s failed: %v", name, err)
+	}
+}
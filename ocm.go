@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultOCMURL is the OCM API gateway used when neither --ocm-url nor the ocm CLI configuration name one.
+	defaultOCMURL = "https://api.openshift.com"
+	// defaultOCMTokenURL is the Red Hat SSO endpoint that exchanges offline tokens for access tokens.
+	defaultOCMTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+	// defaultOCMClientID is the SSO client the ocm CLI and console offline tokens are issued to.
+	defaultOCMClientID = "cloud-services"
+)
+
+// ocmOptions holds the flags that configure the OCM API client.
+type ocmOptions struct {
+	URL      string
+	Token    string
+	TokenURL string
+}
+
+// register adds the OCM flags to a subcommand's flag set.
+func (o *ocmOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.URL, "ocm-url", "", "OCM API URL, defaults to the URL of the ocm CLI login or "+defaultOCMURL)
+	fs.StringVar(&o.Token, "ocm-token", "", "OCM offline or access token, defaults to $OCM_TOKEN or the token of the ocm CLI login")
+	fs.StringVar(&o.TokenURL, "ocm-token-url", "", "SSO endpoint used to refresh OCM tokens, defaults to "+defaultOCMTokenURL)
+}
+
+// ocmReference points at another OCM object.
+type ocmReference struct {
+	ClusterID string `json:"cluster_id"`
+	Href      string `json:"href"`
+}
+
+// ocmManagementCluster is a management cluster of the fleet management API.
+type ocmManagementCluster struct {
+	ID                         string       `json:"id"`
+	Name                       string       `json:"name"`
+	Region                     string       `json:"region"`
+	Status                     string       `json:"status"`
+	ClusterManagementReference ocmReference `json:"cluster_management_reference"`
+	Parent                     struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Kind string `json:"kind"`
+		Href string `json:"href"`
+	} `json:"parent"`
+}
+
+// ocmServiceCluster is a service cluster of the fleet management API.
+type ocmServiceCluster struct {
+	ID                         string       `json:"id"`
+	Name                       string       `json:"name"`
+	Region                     string       `json:"region"`
+	Status                     string       `json:"status"`
+	ClusterManagementReference ocmReference `json:"cluster_management_reference"`
+}
+
+// ocmManagementClusterList is a page of management clusters.
+type ocmManagementClusterList struct {
+	Page  int                    `json:"page"`
+	Size  int                    `json:"size"`
+	Total int                    `json:"total"`
+	Items []ocmManagementCluster `json:"items"`
+}
+
+// ocmCluster is the part of a clusters_mgmt cluster drtest reads.
+type ocmCluster struct {
+	ID         string `json:"id"`
+	ExternalID string `json:"external_id"`
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	API        struct {
+		URL string `json:"url"`
+	} `json:"api"`
+	AWS struct {
+		STS struct {
+			OIDCEndpointURL string `json:"oidc_endpoint_url"`
+		} `json:"sts"`
+	} `json:"aws"`
+}
+
+// ocmClusterList is a page of clusters_mgmt clusters.
+type ocmClusterList struct {
+	Items []ocmCluster `json:"items"`
+}
+
+// ocmError is the error body returned by the OCM API.
+type ocmError struct {
+	Status int    `json:"-"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (e *ocmError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("OCM API returned status %d: %s", e.Status, e.Reason)
+	}
+	return fmt.Sprintf("OCM API returned status %d (%s): %s", e.Status, e.Code, e.Reason)
+}
+
+// ocmClient is a small client for the OCM fleet management and clusters APIs.
+// In dry-run mode every call is printed as part of the plan and nothing is sent.
+type ocmClient struct {
+	baseURL string
+	http    *http.Client
+	tokens  *ocmTokenSource
+	dryRun  *dryRunExecutor
+}
+
+// newOCMClient builds an OCM client from the flags, $OCM_TOKEN and the ocm CLI configuration.
+// API calls go through httpTransport, so they are recorded and replayed with the rest of the run.
+func newOCMClient(opts ocmOptions, execOpts executorOptions) (*ocmClient, error) {
+	if dryRun, ok := executor.(*dryRunExecutor); ok {
+		return &ocmClient{baseURL: firstNonEmpty(opts.URL, defaultOCMURL), dryRun: dryRun}, nil
+	}
+
+	cfg, err := loadOCMConfig()
+	if err != nil {
+		return nil, err
+	}
+	c := &ocmClient{
+		baseURL: strings.TrimRight(firstNonEmpty(opts.URL, cfg.URL, defaultOCMURL), "/"),
+		http:    &http.Client{Transport: httpTransport},
+	}
+	if execOpts.Replay != "" {
+		// Replayed requests are never sent, so no real token is needed to authorize them.
+		c.tokens = &ocmTokenSource{accessToken: "replay", expiry: time.Now().Add(24 * time.Hour)}
+		return c, nil
+	}
+
+	c.tokens = &ocmTokenSource{
+		tokenURL: firstNonEmpty(opts.TokenURL, cfg.TokenURL, defaultOCMTokenURL),
+		clientID: firstNonEmpty(cfg.ClientID, defaultOCMClientID),
+	}
+	if token := firstNonEmpty(opts.Token, os.Getenv("OCM_TOKEN")); token != "" {
+		c.tokens.setToken(token)
+	} else {
+		// A missing token is only reported when a call is made, a resumed run may not need OCM at all.
+		c.tokens.setToken(cfg.AccessToken)
+		c.tokens.refreshToken = cfg.RefreshToken
+	}
+	return c, nil
+}
+
+// FindManagementCluster returns the management cluster with the given name in region.
+// An empty region matches the name in any region.
+func (c *ocmClient) FindManagementCluster(ctx context.Context, name, region string) (*ocmManagementCluster, error) {
+	search := "name=" + ocmSearchString(name)
+	if region != "" {
+		search = fmt.Sprintf("region=%s and %s", ocmSearchString(region), search)
+	}
+	query := url.Values{"search": {search}}
+	if c.dryRun != nil {
+		href := c.plan("/api/osd_fleet_mgmt/v1/management_clusters", query)
+		return &ocmManagementCluster{Name: name, Region: region, ClusterManagementReference: ocmReference{Href: href}}, nil
+	}
+	var list ocmManagementClusterList
+	if err := c.get(ctx, "/api/osd_fleet_mgmt/v1/management_clusters", query, &list); err != nil {
+		return nil, fmt.Errorf("failed to list management clusters: %w", err)
+	}
+	switch len(list.Items) {
+	case 0:
+		if region == "" {
+			return nil, fmt.Errorf("management cluster %s not found", name)
+		}
+		return nil, fmt.Errorf("management cluster %s not found in %s", name, region)
+	case 1:
+		return &list.Items[0], nil
+	default:
+		return nil, fmt.Errorf("%d management clusters named %s found, pass the region to pick one", len(list.Items), name)
+	}
+}
+
+// GetServiceCluster returns the service cluster at href, e.g. the parent of a management cluster.
+func (c *ocmClient) GetServiceCluster(ctx context.Context, href string) (*ocmServiceCluster, error) {
+	var sc ocmServiceCluster
+	if c.dryRun != nil {
+		sc.ClusterManagementReference.Href = c.plan(href, nil)
+		return &sc, nil
+	}
+	if href == "" {
+		return nil, fmt.Errorf("the management cluster has no parent service cluster")
+	}
+	if err := c.get(ctx, href, nil, &sc); err != nil {
+		return nil, fmt.Errorf("failed to get service cluster %s: %w", href, err)
+	}
+	return &sc, nil
+}
+
+// GetCluster returns the clusters_mgmt cluster at href, e.g. the cluster_management_reference of a management cluster.
+func (c *ocmClient) GetCluster(ctx context.Context, href string) (*ocmCluster, error) {
+	var cluster ocmCluster
+	if c.dryRun != nil {
+		cluster.AWS.STS.OIDCEndpointURL = c.plan(href, nil)
+		return &cluster, nil
+	}
+	if err := c.get(ctx, href, nil, &cluster); err != nil {
+		return nil, fmt.Errorf("failed to get cluster %s: %w", href, err)
+	}
+	return &cluster, nil
+}
+
+// FindCluster returns the clusters_mgmt cluster whose ID or external ID is clusterID, or nil when
+// OCM does not know it, e.g. because it was uninstalled.
+func (c *ocmClient) FindCluster(ctx context.Context, clusterID string) (*ocmCluster, error) {
+	query := url.Values{"search": {fmt.Sprintf("id=%s or external_id=%s", ocmSearchString(clusterID), ocmSearchString(clusterID))}}
+	if c.dryRun != nil {
+		return &ocmCluster{ID: clusterID, State: c.plan("/api/clusters_mgmt/v1/clusters", query)}, nil
+	}
+	var list ocmClusterList
+	if err := c.get(ctx, "/api/clusters_mgmt/v1/clusters", query, &list); err != nil {
+		return nil, fmt.Errorf("failed to search cluster %s: %w", clusterID, err)
+	}
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+	return &list.Items[0], nil
+}
+
+// ocmSearchString quotes a value for an OCM search expression. A quote inside the value is
+// doubled, so the value cannot end the string and change the query.
+func ocmSearchString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// plan prints a planned call in dry-run mode and returns its placeholder output.
+func (c *ocmClient) plan(path string, query url.Values) string {
+	args := []string{"get", path}
+	for key, values := range query {
+		for _, value := range values {
+			args = append(args, "-p", key+"="+value)
+		}
+	}
+	return c.dryRun.plan("ocm", args...)
+}
+
+// get sends an authorized GET request for path and decodes the JSON response into out.
+func (c *ocmClient) get(ctx context.Context, path string, query url.Values, out any) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	token, err := c.tokens.token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &ocmError{Status: resp.StatusCode}
+		if json.Unmarshal(body, apiErr) != nil || apiErr.Reason == "" {
+			apiErr.Reason = strings.TrimSpace(string(body))
+		}
+		return apiErr
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// ocmTokenSource hands out OCM access tokens and refreshes them with the offline or refresh token.
+// Token requests carry credentials, so they are sent directly and never go through httpTransport
+// into a transcript.
+type ocmTokenSource struct {
+	mu           sync.Mutex
+	tokenURL     string
+	clientID     string
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+}
+
+// setToken stores a token given by the user. Access tokens are used as they are, offline and
+// refresh tokens are exchanged for an access token on first use.
+func (s *ocmTokenSource) setToken(token string) {
+	if token == "" {
+		return
+	}
+	claims, err := parseJWTClaims(token)
+	if err != nil || (claims.Type != "" && claims.Type != "Bearer") {
+		s.refreshToken = token
+		return
+	}
+	s.accessToken = token
+	s.expiry = claims.expiry()
+}
+
+// token returns a valid access token, refreshing it when it expires within the next minute.
+func (s *ocmTokenSource) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && (s.expiry.IsZero() || time.Until(s.expiry) > time.Minute) {
+		return s.accessToken, nil
+	}
+	if s.accessToken == "" && s.refreshToken == "" {
+		return "", fmt.Errorf("no OCM token found, pass --ocm-token, set OCM_TOKEN or run 'ocm login'")
+	}
+	if s.refreshToken == "" {
+		return "", fmt.Errorf("the OCM access token has expired and no refresh token is available, run 'ocm login' again")
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {s.clientID},
+		"refresh_token": {s.refreshToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh the OCM token: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode the OCM token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("failed to refresh the OCM token: status %d: %s", resp.StatusCode, result.ErrorDescription)
+	}
+
+	s.accessToken = result.AccessToken
+	if result.RefreshToken != "" {
+		s.refreshToken = result.RefreshToken
+	}
+	s.expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return s.accessToken, nil
+}
+
+// jwtClaims are the claims of an SSO token drtest looks at. The signature is not verified,
+// the claims only decide whether and when a token needs refreshing.
+type jwtClaims struct {
+	Type      string `json:"typ"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// expiry returns the expiry time of the token, or the zero time when it does not expire.
+func (c jwtClaims) expiry() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// parseJWTClaims decodes the payload of a JWT.
+func parseJWTClaims(token string) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, err
+	}
+	err = json.Unmarshal(payload, &claims)
+	return claims, err
+}
+
+// ocmConfig is the part of the ocm CLI configuration file drtest reads.
+type ocmConfig struct {
+	URL          string `json:"url"`
+	TokenURL     string `json:"token_url"`
+	ClientID     string `json:"client_id"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// loadOCMConfig reads the configuration saved by 'ocm login', from $OCM_CONFIG or
+// ~/.config/ocm/ocm.json. A missing file is not an error.
+func loadOCMConfig() (*ocmConfig, error) {
+	path := os.Getenv("OCM_CONFIG")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return &ocmConfig{}, nil
+		}
+		path = filepath.Join(dir, "ocm", "ocm.json")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ocmConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCM configuration %s: %w", path, err)
+	}
+	var cfg ocmConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse OCM configuration %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
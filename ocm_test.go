@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ocmStub is a local OCM API and SSO token endpoint. It hands out access tokens acc-1, acc-2, ...
+// and rotates the refresh token on every refresh. It knows the management cluster hs-mc-1 in
+// us-west-2, whose cluster mc1 has an OIDC endpoint, and hs-mc-2, whose cluster mc2 has none.
+type ocmStub struct {
+	mu            sync.Mutex
+	refreshTokens []string
+	searches      []string
+}
+
+// stubManagementClusters are the management clusters the stub returns, by search expression.
+var stubManagementClusters = map[string]string{
+	"region='us-west-2' and name='hs-mc-1'": `{"id":"1","name":"hs-mc-1","region":"us-west-2","cluster_management_reference":{"cluster_id":"mc1","href":"/api/clusters_mgmt/v1/clusters/mc1"}}`,
+	"name='hs-mc-1'":                        `{"id":"1","name":"hs-mc-1","region":"us-west-2","cluster_management_reference":{"cluster_id":"mc1","href":"/api/clusters_mgmt/v1/clusters/mc1"}}`,
+	"name='hs-mc-2'":                        `{"id":"2","name":"hs-mc-2","region":"us-west-2","cluster_management_reference":{"cluster_id":"mc2","href":"/api/clusters_mgmt/v1/clusters/mc2"}}`,
+}
+
+// stubClusters are the clusters_mgmt clusters the stub returns, by ID.
+var stubClusters = map[string]string{
+	"mc1": `{"id":"mc1","name":"hs-mc-1","state":"ready","api":{"url":"https://api.hs-mc-1.example.com:6443"},"aws":{"sts":{"oidc_endpoint_url":"https://oidc.example.com/2juq"}}}`,
+	"mc2": `{"id":"mc2","name":"hs-mc-2","state":"ready","api":{"url":"https://api.hs-mc-2.example.com:6443"}}`,
+}
+
+func (s *ocmStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path == "/token" {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" {
+			http.Error(w, `{"error_description":"bad grant"}`, http.StatusBadRequest)
+			return
+		}
+		s.refreshTokens = append(s.refreshTokens, r.PostForm.Get("refresh_token"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  fmt.Sprintf("acc-%d", len(s.refreshTokens)),
+			"refresh_token": fmt.Sprintf("ref-%d", len(s.refreshTokens)),
+			"expires_in":    300,
+		})
+		return
+	}
+	if r.Header.Get("Authorization") != fmt.Sprintf("Bearer acc-%d", len(s.refreshTokens)) {
+		http.Error(w, `{"code":"CLUSTERS-MGMT-401","reason":"stale token"}`, http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == "/api/clusters_mgmt/v1/clusters":
+		s.searches = append(s.searches, r.URL.Query().Get("search"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"items": []map[string]any{{"id": "2abc", "external_id": "ext-1", "name": "hosted", "state": "ready"}},
+		})
+	case r.URL.Path == "/api/osd_fleet_mgmt/v1/management_clusters":
+		search := r.URL.Query().Get("search")
+		s.searches = append(s.searches, search)
+		items := "[]"
+		if mc, ok := stubManagementClusters[search]; ok {
+			items = "[" + mc + "]"
+		}
+		fmt.Fprintf(w, `{"kind":"ManagementClusterList","items":%s}`, items)
+	case strings.HasPrefix(r.URL.Path, "/api/clusters_mgmt/v1/clusters/"):
+		id := strings.TrimPrefix(r.URL.Path, "/api/clusters_mgmt/v1/clusters/")
+		cluster, ok := stubClusters[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"code":"CLUSTERS-MGMT-404","reason":"Cluster '%s' not found"}`, id)
+			return
+		}
+		fmt.Fprint(w, cluster)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newStubOCMClient returns an OCM client that talks to a local ocmStub with an offline token.
+func newStubOCMClient(t *testing.T) (*ocmClient, *ocmStub) {
+	t.Helper()
+	stub := &ocmStub{}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	t.Setenv("OCM_CONFIG", filepath.Join(t.TempDir(), "ocm.json"))
+	t.Setenv("OCM_TOKEN", "")
+
+	c, err := newOCMClient(ocmOptions{URL: server.URL, Token: "offline", TokenURL: server.URL + "/token"}, executorOptions{})
+	if err != nil {
+		t.Fatalf("newOCMClient: %v", err)
+	}
+	return c, stub
+}
+
+func TestOCMClientRefreshesToken(t *testing.T) {
+	c, stub := newStubOCMClient(t)
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := c.FindCluster(ctx, "2abc"); err != nil {
+			t.Fatalf("FindCluster: %v", err)
+		}
+	}
+	if len(stub.refreshTokens) != 1 || stub.refreshTokens[0] != "offline" {
+		t.Fatalf("refreshed with %q, want the offline token once", stub.refreshTokens)
+	}
+
+	// An access token about to expire is refreshed with the rotated refresh token.
+	c.tokens.expiry = time.Now().Add(30 * time.Second)
+	if _, err := c.FindCluster(ctx, "2abc"); err != nil {
+		t.Fatalf("FindCluster after expiry: %v", err)
+	}
+	if len(stub.refreshTokens) != 2 || stub.refreshTokens[1] != "ref-1" {
+		t.Fatalf("refreshed with %q, want the rotated refresh token ref-1 second", stub.refreshTokens)
+	}
+}
+
+func TestOCMClientFindClusterSearch(t *testing.T) {
+	c, stub := newStubOCMClient(t)
+
+	tests := []struct {
+		clusterID string
+		search    string
+	}{
+		{"2abc", "id='2abc' or external_id='2abc'"},
+		{"x' or name='y", "id='x'' or name=''y' or external_id='x'' or name=''y'"},
+	}
+	for _, tt := range tests {
+		cluster, err := c.FindCluster(context.Background(), tt.clusterID)
+		if err != nil {
+			t.Fatalf("FindCluster(%q): %v", tt.clusterID, err)
+		}
+		if cluster == nil || cluster.State != "ready" {
+			t.Fatalf("FindCluster(%q) = %+v, want the ready cluster", tt.clusterID, cluster)
+		}
+		if got := stub.searches[len(stub.searches)-1]; got != tt.search {
+			t.Errorf("FindCluster(%q) searched %q, want %q", tt.clusterID, got, tt.search)
+		}
+	}
+}
+
+func TestOCMClientFindManagementCluster(t *testing.T) {
+	c, stub := newStubOCMClient(t)
+
+	tests := []struct {
+		name, region string
+		search       string
+		wantHref     string
+		wantErr      string
+	}{
+		{name: "hs-mc-1", region: "us-west-2", search: "region='us-west-2' and name='hs-mc-1'", wantHref: "/api/clusters_mgmt/v1/clusters/mc1"},
+		{name: "hs-mc-1", search: "name='hs-mc-1'", wantHref: "/api/clusters_mgmt/v1/clusters/mc1"},
+		{name: "hs-mc-3", region: "us-west-2", search: "region='us-west-2' and name='hs-mc-3'", wantErr: "management cluster hs-mc-3 not found in us-west-2"},
+		// A quote in the name stays inside the quoted value instead of adding a clause that matches hs-mc-1.
+		{name: "x' or name='hs-mc-1", search: "name='x'' or name=''hs-mc-1'", wantErr: "management cluster x' or name='hs-mc-1 not found"},
+	}
+	for _, tt := range tests {
+		mc, err := c.FindManagementCluster(context.Background(), tt.name, tt.region)
+		if got := stub.searches[len(stub.searches)-1]; got != tt.search {
+			t.Errorf("FindManagementCluster(%q, %q) searched %q, want %q", tt.name, tt.region, got, tt.search)
+		}
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("FindManagementCluster(%q, %q) error = %v, want %q", tt.name, tt.region, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("FindManagementCluster(%q, %q): %v", tt.name, tt.region, err)
+		}
+		if mc.ClusterManagementReference.Href != tt.wantHref {
+			t.Errorf("FindManagementCluster(%q, %q) href = %q, want %q", tt.name, tt.region, mc.ClusterManagementReference.Href, tt.wantHref)
+		}
+	}
+}
+
+func TestOCMClientGetCluster(t *testing.T) {
+	c, _ := newStubOCMClient(t)
+	ctx := context.Background()
+
+	cluster, err := c.GetCluster(ctx, "/api/clusters_mgmt/v1/clusters/mc1")
+	if err != nil {
+		t.Fatalf("GetCluster: %v", err)
+	}
+	if cluster.API.URL != "https://api.hs-mc-1.example.com:6443" || cluster.AWS.STS.OIDCEndpointURL != "https://oidc.example.com/2juq" {
+		t.Errorf("GetCluster = %+v, want the API and OIDC endpoint of mc1", cluster)
+	}
+
+	_, err = c.GetCluster(ctx, "/api/clusters_mgmt/v1/clusters/missing")
+	var ocmErr *ocmError
+	if !errors.As(err, &ocmErr) || ocmErr.Status != http.StatusNotFound || ocmErr.Code != "CLUSTERS-MGMT-404" {
+		t.Errorf("GetCluster of a missing cluster: got %v, want the OCM 404 error", err)
+	}
+}
+
+func TestManagementClusterOIDC(t *testing.T) {
+	c, _ := newStubOCMClient(t)
+
+	tests := []struct {
+		name, region string
+		wantURL      string
+		wantID       string
+		wantErr      string
+	}{
+		{name: "hs-mc-1", region: "us-west-2", wantURL: "https://oidc.example.com/2juq", wantID: "oidc.example.com/2juq"},
+		{name: "hs-mc-2", wantErr: "OIDC endpoint URL not found"},
+		{name: "hs-mc-3", region: "us-west-2", wantErr: "failed to get management cluster href: management cluster hs-mc-3 not found in us-west-2"},
+	}
+	for _, tt := range tests {
+		url, id, err := managementClusterOIDC(context.Background(), c, tt.name, tt.region)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("managementClusterOIDC(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("managementClusterOIDC(%q): %v", tt.name, err)
+		}
+		if url != tt.wantURL || id != tt.wantID {
+			t.Errorf("managementClusterOIDC(%q) = %q, %q, want %q, %q", tt.name, url, id, tt.wantURL, tt.wantID)
+		}
+	}
+}
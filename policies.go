@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// kmsAdminActions are the key management actions granted to the key administrators.
+// They allow managing and deleting the key but not using it to encrypt or decrypt.
+var kmsAdminActions = []string{
+	"kms:Create*",
+	"kms:Describe*",
+	"kms:Enable*",
+	"kms:List*",
+	"kms:Put*",
+	"kms:Update*",
+	"kms:Revoke*",
+	"kms:Disable*",
+	"kms:Get*",
+	"kms:Delete*",
+	"kms:TagResource",
+	"kms:UntagResource",
+	"kms:ScheduleKeyDeletion",
+	"kms:CancelKeyDeletion",
+}
+
+// kmsUserActions are the actions the backup role needs to read and write encrypted backups.
+var kmsUserActions = []string{
+	"kms:Encrypt",
+	"kms:Decrypt",
+	"kms:GenerateDataKey",
+	"kms:DescribeKey",
+}
+
+// veleroObjectActions are the object actions the Velero AWS plugin needs under the backup prefix.
+// PutObjectTagging is needed because the BSL tags every object it writes.
+var veleroObjectActions = []string{
+	"s3:GetObject",
+	"s3:PutObject",
+	"s3:PutObjectTagging",
+	"s3:DeleteObject",
+	"s3:AbortMultipartUpload",
+	"s3:ListMultipartUploadParts",
+}
+
+// policyDocument is an IAM or KMS key policy.
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+// policyStatement is one statement of a policy. Principal, Action and Resource hold either a
+// string or a list, as they do in the policy language.
+type policyStatement struct {
+	Sid       string                    `json:"Sid,omitempty"`
+	Effect    string                    `json:"Effect"`
+	Principal any                       `json:"Principal,omitempty"`
+	Action    any                       `json:"Action"`
+	Resource  any                       `json:"Resource"`
+	Condition map[string]map[string]any `json:"Condition,omitempty"`
+}
+
+// iamPrincipalArn matches the ARN of an IAM user or role.
+var iamPrincipalArn = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}):(user|role)/.+$`)
+
+// kmsAdminPatterns turns the --kms-admin values into aws:PrincipalArn patterns. A value is the
+// ARN of an IAM user or role, or sso:<permission set name> for the roles IAM Identity Center
+// creates for a permission set. Without values the caller of the run becomes the administrator.
+func kmsAdminPatterns(admins []string, account, callerArn string) ([]string, error) {
+	if len(admins) == 0 {
+		patterns, err := callerPrincipalPatterns(callerArn)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use the caller as the KMS key administrator, pass --kms-admin: %w", err)
+		}
+		return patterns, nil
+	}
+
+	var patterns []string
+	for _, admin := range admins {
+		pattern, err := kmsAdminPattern(admin, account)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// kmsAdminPattern returns the aws:PrincipalArn pattern of one --kms-admin value in account.
+func kmsAdminPattern(admin, account string) (string, error) {
+	switch {
+	case strings.HasPrefix(admin, "sso:"):
+		permissionSet := strings.TrimPrefix(admin, "sso:")
+		if permissionSet == "" || strings.ContainsAny(permissionSet, "*?/") {
+			return "", fmt.Errorf("invalid --kms-admin %q, expected sso:<permission set name>", admin)
+		}
+		// Identity Center roles live under aws-reserved/sso.amazonaws.com/, optionally followed by a region.
+		return fmt.Sprintf("arn:aws:iam::%s:role/aws-reserved/sso.amazonaws.com/*AWSReservedSSO_%s_*", account, permissionSet), nil
+	case iamPrincipalArn.MatchString(admin):
+		return admin, nil
+	default:
+		return "", fmt.Errorf("invalid --kms-admin %q, expected the ARN of an IAM user or role, or sso:<permission set name>", admin)
+	}
+}
+
+// callerPrincipalPatterns returns the aws:PrincipalArn patterns of the caller identity. An assumed
+// role session is matched by its role, wherever the role's path puts it.
+func callerPrincipalPatterns(callerArn string) ([]string, error) {
+	if iamPrincipalArn.MatchString(callerArn) {
+		return []string{callerArn}, nil
+	}
+	// arn:aws:sts::<account>:assumed-role/<role name>/<session name>
+	parts := strings.SplitN(callerArn, ":", 6)
+	if len(parts) == 6 && parts[2] == "sts" && strings.HasPrefix(parts[5], "assumed-role/") {
+		roleName := strings.Split(parts[5], "/")[1]
+		return []string{
+			fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], roleName),
+			fmt.Sprintf("arn:%s:iam::%s:role/*/%s", parts[1], parts[4], roleName),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported caller identity %s", callerArn)
+}
+
+// buildKMSKeyPolicy returns the key policy of the backup key: the account root keeps full access,
+// so IAM policies keep working and the key can always be recovered, the backup role may use the
+// key, and the administrators may manage it.
+func buildKMSKeyPolicy(account, roleArn string, adminPatterns []string) (string, error) {
+	doc := policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{
+				Sid:       "EnableRootAccountAccess",
+				Effect:    "Allow",
+				Principal: map[string]any{"AWS": rootArn(account)},
+				Action:    "kms:*",
+				Resource:  "*",
+			},
+			{
+				Sid:       "AllowClusterRoleAccess",
+				Effect:    "Allow",
+				Principal: map[string]any{"AWS": roleArn},
+				Action:    kmsUserActions,
+				Resource:  "*",
+			},
+			{
+				Sid:       "AllowKeyAdministrators",
+				Effect:    "Allow",
+				Principal: map[string]any{"AWS": "*"},
+				Action:    kmsAdminActions,
+				Resource:  "*",
+				Condition: map[string]map[string]any{
+					"StringEquals": {"aws:PrincipalAccount": account},
+					"ArnLike":      {"aws:PrincipalArn": adminPatterns},
+				},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := validateKMSKeyPolicy(string(data), account); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// buildBucketAccessPolicy returns the inline policy of the backup role. It grants the Velero
+// actions on the objects under the backup prefix of bucket and listing that prefix, nothing else.
+func buildBucketAccessPolicy(bucket string) (string, error) {
+	doc := policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{
+				Sid:      "VeleroBackupObjects",
+				Effect:   "Allow",
+				Action:   veleroObjectActions,
+				Resource: fmt.Sprintf("arn:aws:s3:::%s/%s/*", bucket, backupPrefix),
+			},
+			{
+				Sid:      "VeleroListBackupPrefix",
+				Effect:   "Allow",
+				Action:   "s3:ListBucket",
+				Resource: "arn:aws:s3:::" + bucket,
+				Condition: map[string]map[string]any{
+					"StringLike": {"s3:prefix": []string{backupPrefix, backupPrefix + "/*"}},
+				},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// buildServiceTrustPolicy returns the trust policy of a role assumed by an AWS service.
+func buildServiceTrustPolicy(service string) (string, error) {
+	doc := policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{{
+			Effect:    "Allow",
+			Principal: map[string]any{"Service": service},
+			Action:    "sts:AssumeRole",
+		}},
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// buildReplicationPolicy returns the inline policy of the replication role: it reads the objects
+// of the source bucket and decrypts them with the source key, and writes them to the replica
+// bucket encrypted with the replica key. KMS is only usable through S3 in each bucket's region.
+func buildReplicationPolicy(source, sourceKeyArn, sourceRegion, replica, replicaKeyArn, replicaRegion string) (string, error) {
+	doc := policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{
+				Sid:      "ReadSourceConfiguration",
+				Effect:   "Allow",
+				Action:   []string{"s3:GetReplicationConfiguration", "s3:ListBucket"},
+				Resource: "arn:aws:s3:::" + source,
+			},
+			{
+				Sid:    "ReadSourceObjects",
+				Effect: "Allow",
+				Action: []string{
+					"s3:GetObjectVersionForReplication",
+					"s3:GetObjectVersionAcl",
+					"s3:GetObjectVersionTagging",
+					"s3:GetObjectRetention",
+					"s3:GetObjectLegalHold",
+				},
+				Resource: "arn:aws:s3:::" + source + "/*",
+			},
+			{
+				Sid:      "WriteReplicaObjects",
+				Effect:   "Allow",
+				Action:   []string{"s3:ReplicateObject", "s3:ReplicateDelete", "s3:ReplicateTags"},
+				Resource: "arn:aws:s3:::" + replica + "/*",
+			},
+			{
+				Sid:      "DecryptSourceObjects",
+				Effect:   "Allow",
+				Action:   "kms:Decrypt",
+				Resource: sourceKeyArn,
+				Condition: map[string]map[string]any{
+					"StringEquals": {"kms:ViaService": fmt.Sprintf("s3.%s.amazonaws.com", sourceRegion)},
+				},
+			},
+			{
+				Sid:      "EncryptReplicaObjects",
+				Effect:   "Allow",
+				Action:   []string{"kms:Encrypt", "kms:GenerateDataKey"},
+				Resource: replicaKeyArn,
+				Condition: map[string]map[string]any{
+					"StringEquals": {"kms:ViaService": fmt.Sprintf("s3.%s.amazonaws.com", replicaRegion)},
+				},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// buildReplicaAccessPolicy returns the inline policy that lets the backup role restore from the
+// replica bucket: reading and listing the backup prefix and decrypting with the replica key.
+func buildReplicaAccessPolicy(replica, replicaKeyArn string) (string, error) {
+	doc := policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{
+				Sid:      "VeleroReadReplicaObjects",
+				Effect:   "Allow",
+				Action:   "s3:GetObject",
+				Resource: fmt.Sprintf("arn:aws:s3:::%s/%s/*", replica, backupPrefix),
+			},
+			{
+				Sid:      "VeleroListReplicaPrefix",
+				Effect:   "Allow",
+				Action:   "s3:ListBucket",
+				Resource: "arn:aws:s3:::" + replica,
+				Condition: map[string]map[string]any{
+					"StringLike": {"s3:prefix": []string{backupPrefix, backupPrefix + "/*"}},
+				},
+			},
+			{
+				Sid:      "VeleroDecryptReplicaObjects",
+				Effect:   "Allow",
+				Action:   []string{"kms:Decrypt", "kms:DescribeKey"},
+				Resource: replicaKeyArn,
+			},
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// crossAccountBucketStatements returns the bucket policy statements that let the backup role of
+// another account use the backup prefix of bucket. A bucket in another account needs them on top
+// of the role's own policy. With readOnly the role may only read and list the prefix.
+func crossAccountBucketStatements(bucket, roleArn string, readOnly bool) []policyStatement {
+	actions := veleroObjectActions
+	if readOnly {
+		actions = []string{"s3:GetObject"}
+	}
+	return []policyStatement{
+		{
+			Sid:       "CrossAccountBackupObjects",
+			Effect:    "Allow",
+			Principal: map[string]any{"AWS": roleArn},
+			Action:    actions,
+			Resource:  fmt.Sprintf("arn:aws:s3:::%s/%s/*", bucket, backupPrefix),
+		},
+		{
+			Sid:       "CrossAccountListBackupPrefix",
+			Effect:    "Allow",
+			Principal: map[string]any{"AWS": roleArn},
+			Action:    "s3:ListBucket",
+			Resource:  "arn:aws:s3:::" + bucket,
+			Condition: map[string]map[string]any{
+				"StringLike": {"s3:prefix": []string{backupPrefix, backupPrefix + "/*"}},
+			},
+		},
+	}
+}
+
+// Sids of the statements the hardening baseline adds to the backup bucket policy.
+const (
+	sidDenyInsecureTransport = "DenyInsecureTransport"
+	sidDenyNonKMSEncryption  = "DenyNonKMSEncryption"
+)
+
+// bucketBaselineStatements returns the bucket policy statements of the hardening baseline: every
+// request without TLS is denied, and so is every upload asking for an encryption other than SSE-KMS.
+// Uploads without encryption headers are encrypted with the bucket's default SSE-KMS key.
+func bucketBaselineStatements(bucket string) []policyStatement {
+	bucketArn := "arn:aws:s3:::" + bucket
+	return []policyStatement{
+		{
+			Sid:       sidDenyInsecureTransport,
+			Effect:    "Deny",
+			Principal: "*",
+			Action:    "s3:*",
+			Resource:  []string{bucketArn, bucketArn + "/*"},
+			Condition: map[string]map[string]any{
+				"Bool": {"aws:SecureTransport": "false"},
+			},
+		},
+		{
+			Sid:       sidDenyNonKMSEncryption,
+			Effect:    "Deny",
+			Principal: "*",
+			Action:    "s3:PutObject",
+			Resource:  bucketArn + "/*",
+			Condition: map[string]map[string]any{
+				"StringNotEquals": {"s3:x-amz-server-side-encryption": "aws:kms"},
+				"Null":            {"s3:x-amz-server-side-encryption": "false"},
+			},
+		},
+	}
+}
+
+// mergePolicyStatements adds statements to a policy, replacing the statements with the same Sid.
+// The other statements are kept as they are, an empty policy starts a new document.
+func mergePolicyStatements(policy string, statements []policyStatement) (string, error) {
+	doc := map[string]any{"Version": "2012-10-17"}
+	if policy != "" {
+		if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+			return "", fmt.Errorf("invalid bucket policy: %w", err)
+		}
+	}
+	replaced := map[string]bool{}
+	for _, st := range statements {
+		replaced[st.Sid] = true
+	}
+	var merged []any
+	existing, ok := doc["Statement"].([]any)
+	if !ok && doc["Statement"] != nil {
+		existing = []any{doc["Statement"]}
+	}
+	for _, st := range existing {
+		if m, ok := st.(map[string]any); ok && replaced[fmt.Sprint(m["Sid"])] {
+			continue
+		}
+		merged = append(merged, st)
+	}
+	for _, st := range statements {
+		merged = append(merged, st)
+	}
+	doc["Statement"] = merged
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// hasDenyCondition reports whether a policy has a Deny statement covering action with a
+// condition on key under operator.
+func hasDenyCondition(doc policyDocument, action, operator, key string) bool {
+	for _, st := range doc.Statement {
+		if st.Effect != "Deny" {
+			continue
+		}
+		actions := stringOrList(st.Action)
+		if !slices.Contains(actions, action) && !slices.Contains(actions, "s3:*") && !slices.Contains(actions, "*") {
+			continue
+		}
+		if _, ok := st.Condition[operator][key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateKMSKeyPolicy makes sure a key policy can never lock the account out of its key: the
+// account root must be allowed every KMS action without conditions, and no statement may deny
+// anything to the root or to every principal.
+func validateKMSKeyPolicy(policy, account string) error {
+	var doc policyDocument
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return fmt.Errorf("invalid key policy: %w", err)
+	}
+	root := rootArn(account)
+	rootAllowed := false
+	for _, st := range doc.Statement {
+		principals := awsPrincipals(st.Principal)
+		coversRoot := slices.Contains(principals, "*") || slices.Contains(principals, root) || slices.Contains(principals, account)
+		switch {
+		case st.Effect == "Deny" && coversRoot:
+			return fmt.Errorf("key policy statement %q denies access to the account root %s", st.Sid, root)
+		case st.Effect == "Allow" && len(st.Condition) == 0 &&
+			(slices.Contains(principals, root) || slices.Contains(principals, account)) &&
+			slices.Contains(stringOrList(st.Action), "kms:*"):
+			rootAllowed = true
+		}
+	}
+	if !rootAllowed {
+		return fmt.Errorf("key policy does not grant kms:* to the account root %s unconditionally, the key could become unmanageable", root)
+	}
+	return nil
+}
+
+// rootArn returns the ARN of an account's root principal.
+func rootArn(account string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:root", account)
+}
+
+// awsPrincipals returns the AWS principals of a statement; "*" stands for every principal.
+func awsPrincipals(principal any) []string {
+	switch p := principal.(type) {
+	case string:
+		return []string{p}
+	case map[string]any:
+		return stringOrList(p["AWS"])
+	}
+	return nil
+}
+
+// stringOrList returns a policy element that holds a string or a list of strings as a list.
+func stringOrList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
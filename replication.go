@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the replication resources of a cluster.
+const (
+	replicationRuleID         = "drtest-dr-replica"
+	replicationPolicyName     = "S3ReplicationToDRReplica"
+	replicaAccessPolicyName   = "VeleroReplicaBucketAccess"
+	replicationRoleNamePrefix = "rosa-hcp-repl-"
+	replicaBucketSuffix       = "-replica"
+	replicaBSLSuffix          = "-replica"
+)
+
+// replicaBucketName returns the name of the replica of a backup bucket.
+func replicaBucketName(bucketName string) string {
+	return bucketName + replicaBucketSuffix
+}
+
+// stepCreateReplica replicates the backup bucket to --replica-region. The replica bucket gets the
+// same hardening and Object Lock settings and its own KMS key, a replication role copies the
+// backups, and the backup role may read the replica so it can serve as a read-only BSL.
+// Every resource is recorded in the ledger as soon as it exists, so a resumed run reuses it.
+func stepCreateReplica(r *configureRun) error {
+	opts, ledger := r.opts, r.ledger
+	if opts.ReplicaRegion == "" {
+		fmt.Println("No --replica-region given, the backups are not replicated.")
+		return nil
+	}
+	if opts.ReplicaRegion == ledger.Region {
+		return fmt.Errorf("--replica-region %s is the region of the backup bucket, pick another region", opts.ReplicaRegion)
+	}
+	fmt.Println("\n--- Cross-Region Replication Setup Started ---")
+	replica := r.vault.withRegion(opts.ReplicaRegion)
+	ledger.ReplicaRegion = opts.ReplicaRegion
+
+	// Step 1: Create the replica bucket
+	if ledger.ReplicaBucketName == "" {
+		bucketName := replicaBucketName(ledger.BucketName)
+		fmt.Printf("Step 1: Creating replica bucket '%s' in region '%s'...\n", bucketName, opts.ReplicaRegion)
+		created, err := createHardenedBucket(r.ctx, replica, bucketName, ledger.ObjectLockMode, ledger.ObjectLockDays)
+		if created {
+			ledger.ReplicaBucketName = bucketName
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		// An earlier attempt may have failed while hardening it, so it is hardened again.
+		fmt.Printf("Step 1: Reusing replica bucket '%s'.\n", ledger.ReplicaBucketName)
+		if err := hardenBucket(r.ctx, replica, ledger.ReplicaBucketName, ledger.ObjectLockMode, ledger.ObjectLockDays); err != nil {
+			return err
+		}
+	}
+
+	// Step 2: Create the replica KMS key and make it the replica's default encryption
+	if ledger.ReplicaKMSKeyArn == "" {
+		fmt.Printf("Step 2: Creating the replica KMS key in region '%s'...\n", opts.ReplicaRegion)
+		keyArn, err := replica.CreateKey(r.ctx, fmt.Sprintf("SSE-KMS backup replica key: %s", opts.ClusterID), clusterTags(opts.ClusterID, opts.ClusterEnv))
+		if err != nil {
+			return fmt.Errorf("failed to create the replica KMS key: %w", err)
+		}
+		rollback.register(fmt.Sprintf("schedule deletion of KMS key %s", keyArn), undoScheduleKeyDeletion(r.ctx, replica, keyArn))
+		ledger.ReplicaKMSKeyArn = keyArn
+	} else {
+		fmt.Printf("Step 2: Reusing replica KMS key '%s'.\n", ledger.ReplicaKMSKeyArn)
+	}
+	adminPatterns, err := kmsAdminPatterns(opts.KMSAdmins, r.vault.account, r.vault.callerArn)
+	if err != nil {
+		return err
+	}
+	keyPolicy, err := buildKMSKeyPolicy(r.vault.account, ledger.RoleArn, adminPatterns)
+	if err != nil {
+		return fmt.Errorf("failed to build the replica key policy: %w", err)
+	}
+	if err := replica.PutKeyPolicy(r.ctx, ledger.ReplicaKMSKeyArn, keyPolicy); err != nil {
+		return fmt.Errorf("failed to put key policy on the replica KMS key: %w", err)
+	}
+	if err := setBucketEncryption(r.ctx, replica, ledger.ReplicaBucketName, ledger.ReplicaKMSKeyArn); err != nil {
+		return err
+	}
+
+	// Step 3: Create the replication role, in the account of the buckets
+	roleName := replicationRoleNamePrefix + opts.MCName + "-" + opts.ClusterID
+	if ledger.ReplicationRoleArn == "" {
+		fmt.Printf("Step 3: Creating replication role '%s'...\n", roleName)
+		trustPolicy, err := buildServiceTrustPolicy("s3.amazonaws.com")
+		if err != nil {
+			return err
+		}
+		roleArn, err := r.vault.CreateRole(r.ctx, roleName, trustPolicy, fmt.Sprintf("backup replication role for cluster %s", opts.ClusterID))
+		if err != nil {
+			return fmt.Errorf("failed to create replication role: %w", err)
+		}
+		rollback.register(fmt.Sprintf("delete IAM role %s", roleName), undoDeleteRole(r.ctx, r.vault.awsClient, roleName))
+		ledger.ReplicationRoleName = roleName
+		ledger.ReplicationRoleArn = roleArn
+	} else {
+		fmt.Printf("Step 3: Reusing replication role '%s'.\n", ledger.ReplicationRoleName)
+	}
+	replicationPolicy, err := buildReplicationPolicy(ledger.BucketName, ledger.KMSKeyArn, ledger.Region,
+		ledger.ReplicaBucketName, ledger.ReplicaKMSKeyArn, ledger.ReplicaRegion)
+	if err != nil {
+		return err
+	}
+	if err := r.vault.PutRolePolicy(r.ctx, ledger.ReplicationRoleName, replicationPolicyName, replicationPolicy); err != nil {
+		return fmt.Errorf("failed to put inline policy %s on role %s: %w", replicationPolicyName, ledger.ReplicationRoleName, err)
+	}
+	rollback.register(fmt.Sprintf("delete inline policy %s of role %s", replicationPolicyName, ledger.ReplicationRoleName),
+		undoDeleteRolePolicy(r.ctx, r.vault.awsClient, ledger.ReplicationRoleName, replicationPolicyName))
+
+	// Step 4: Replicate the backup prefix of the bucket
+	fmt.Printf("Step 4: Replicating s3://%s/%s/ to bucket '%s'...\n", ledger.BucketName, backupPrefix, ledger.ReplicaBucketName)
+	if err := r.vault.PutBucketReplication(r.ctx, ledger.BucketName, ledger.ReplicationRoleArn, replicationRuleID,
+		backupPrefix+"/", ledger.ReplicaBucketName, ledger.ReplicaKMSKeyArn); err != nil {
+		return fmt.Errorf("failed to configure the replication of bucket %s: %w", ledger.BucketName, err)
+	}
+	rollback.register(fmt.Sprintf("delete the replication configuration of bucket %s", ledger.BucketName),
+		undoDeleteBucketReplication(r.ctx, r.vault.awsClient, ledger.BucketName))
+	ledger.ReplicationRuleID = replicationRuleID
+
+	// Step 5: Let the backup role read the replica for restores
+	roleName = roleNameFromArn(ledger.RoleArn)
+	fmt.Printf("Step 5: Granting role '%s' read access to bucket '%s'...\n", roleName, ledger.ReplicaBucketName)
+	accessPolicy, err := buildReplicaAccessPolicy(ledger.ReplicaBucketName, ledger.ReplicaKMSKeyArn)
+	if err != nil {
+		return err
+	}
+	if err := r.aws.PutRolePolicy(r.ctx, roleName, replicaAccessPolicyName, accessPolicy); err != nil {
+		return fmt.Errorf("failed to put inline policy %s on role %s: %w", replicaAccessPolicyName, roleName, err)
+	}
+	rollback.register(fmt.Sprintf("delete inline policy %s of role %s", replicaAccessPolicyName, roleName),
+		undoDeleteRolePolicy(r.ctx, r.aws, roleName, replicaAccessPolicyName))
+	ledger.addInlinePolicy(replicaAccessPolicyName)
+	if r.crossAccount() {
+		if err := grantVaultBucketAccess(r.ctx, replica, ledger.ReplicaBucketName, ledger.RoleArn, true); err != nil {
+			return err
+		}
+	}
+	ledger.ReplicaBSLName = opts.ClusterID + replicaBSLSuffix
+
+	fmt.Println("--- Cross-Region Replication Setup Completed ---")
+	return nil
+}
+
+// replicaBSLManifest returns the read-only BackupStorageLocation of the replica bucket. It shares
+// the credentials of the primary BSL, whose role may read the replica.
+func replicaBSLManifest(ledger *Ledger) string {
+	return strings.NewReplacer(
+		"${NAME}", ledger.ReplicaBSLName,
+		"${CLUSTER_ID}", ledger.ClusterID,
+		"${BUCKET_NAME}", ledger.ReplicaBucketName,
+		"${PREFIX}", backupPrefix,
+		"${REGION}", ledger.ReplicaRegion,
+		"${KMS_KEY_ID}", ledger.ReplicaKMSKeyArn,
+	).Replace(`apiVersion: velero.io/v1
+kind: BackupStorageLocation
+metadata:
+  name: ${NAME}
+  namespace: openshift-adp
+spec:
+  provider: aws
+  accessMode: ReadOnly
+  objectStorage:
+    bucket: ${BUCKET_NAME}
+    prefix: ${PREFIX}
+  credential:
+    name: ${CLUSTER_ID}-backup-role
+    key: credentials
+  config:
+    region: ${REGION}
+    profile: default
+    kmsKeyId: ${KMS_KEY_ID}
+`)
+}
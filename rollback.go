@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// compensatingAction undoes one resource created by configure.
+type compensatingAction struct {
+	description string
+	undo        func() error
+}
+
+// rollbackStack collects the compensating actions of the resources created by the current run.
+type rollbackStack struct {
+	actions []compensatingAction
+}
+
+// rollback is the stack the creation steps register their compensating actions on.
+var rollback rollbackStack
+
+// register records how to undo a resource that was just created.
+func (r *rollbackStack) register(description string, undo func() error) {
+	r.actions = append(r.actions, compensatingAction{description: description, undo: undo})
+}
+
+// run undoes the registered actions in reverse order of creation and empties the stack.
+// Every action is attempted, the errors of the ones that failed are returned.
+func (r *rollbackStack) run() []error {
+	fmt.Println("\n--- Rollback Started ---")
+	var failed []error
+	for i := len(r.actions) - 1; i >= 0; i-- {
+		action := r.actions[i]
+		fmt.Printf("Rollback: %s...\n", action.description)
+		if err := action.undo(); err != nil {
+			fmt.Printf("Rollback of '%s' failed: %v\n", action.description, err)
+			failed = append(failed, fmt.Errorf("%s: %w", action.description, err))
+		}
+	}
+	r.actions = nil
+	fmt.Println("--- Rollback Completed ---")
+	return failed
+}
+
+// undoDeleteBucket deletes an empty bucket created by this run.
+func undoDeleteBucket(ctx context.Context, c *awsClient, bucketName string) func() error {
+	return func() error {
+		return c.DeleteBucket(ctx, bucketName)
+	}
+}
+
+// undoDeleteBucketReplication removes a replication configuration put on a bucket by this run.
+func undoDeleteBucketReplication(ctx context.Context, c *awsClient, bucketName string) func() error {
+	return func() error {
+		return c.DeleteBucketReplication(ctx, bucketName)
+	}
+}
+
+// undoDeleteRole deletes a role created by this run. Its policies are detached by earlier undo actions.
+func undoDeleteRole(ctx context.Context, c *awsClient, roleName string) func() error {
+	return func() error {
+		return c.DeleteRole(ctx, roleName)
+	}
+}
+
+// undoDeleteOIDCProvider deletes an IAM OIDC provider created by this run.
+func undoDeleteOIDCProvider(ctx context.Context, c *awsClient, providerArn string) func() error {
+	return func() error {
+		return c.DeleteOIDCProvider(ctx, providerArn)
+	}
+}
+
+// undoDetachRolePolicy detaches a policy attached to the role by this run.
+func undoDetachRolePolicy(ctx context.Context, c *awsClient, roleName, policyArn string) func() error {
+	return func() error {
+		return c.DetachRolePolicy(ctx, roleName, policyArn)
+	}
+}
+
+// undoDeleteRolePolicy deletes an inline policy put on the role by this run.
+func undoDeleteRolePolicy(ctx context.Context, c *awsClient, roleName, policyName string) func() error {
+	return func() error {
+		return c.DeleteRolePolicy(ctx, roleName, policyName)
+	}
+}
+
+// undoDeletePolicy deletes a customer managed policy created by this run.
+func undoDeletePolicy(ctx context.Context, c *awsClient, policyArn string) func() error {
+	return func() error {
+		return c.DeletePolicy(ctx, policyArn)
+	}
+}
+
+// undoScheduleKeyDeletion schedules the deletion of a KMS key created by this run.
+// KMS keys cannot be deleted right away, the shortest waiting period of 7 days is used.
+func undoScheduleKeyDeletion(ctx context.Context, c *awsClient, kmsArn string) func() error {
+	return func() error {
+		_, err := c.ScheduleKeyDeletion(ctx, kmsArn, minKeyPendingDays)
+		return err
+	}
+}
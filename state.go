@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Ledger records every resource configure created for a cluster.
+// Teardown deletes exactly what the ledger lists instead of rediscovering it.
+type Ledger struct {
+	ClusterID   string `json:"cluster_id"`
+	ClusterName string `json:"cluster_name,omitempty"`
+	ClusterEnv  string `json:"cluster_env,omitempty"`
+	MCName      string `json:"mc_name"`
+	Region      string `json:"region,omitempty"`
+
+	VaultAccountID      string   `json:"vault_account_id,omitempty"`
+	BucketName          string   `json:"bucket_name,omitempty"`
+	ObjectLockMode      string   `json:"object_lock_mode,omitempty"`
+	ObjectLockDays      int32    `json:"object_lock_days,omitempty"`
+	OIDCURL             string   `json:"oidc_url,omitempty"`
+	OIDCIssuer          string   `json:"oidc_issuer,omitempty"`
+	OIDCProviderArn     string   `json:"oidc_provider_arn,omitempty"`
+	OIDCProviderCreated bool     `json:"oidc_provider_created,omitempty"`
+	RoleName            string   `json:"role_name,omitempty"`
+	RoleArn             string   `json:"role_arn,omitempty"`
+	AttachedPolicyArns  []string `json:"attached_policy_arns,omitempty"`
+	InlinePolicyNames   []string `json:"inline_policy_names,omitempty"`
+	KMSKeyArn           string   `json:"kms_key_arn,omitempty"`
+	KMSPolicyName       string   `json:"kms_policy_name,omitempty"`
+	KMSPolicyArn        string   `json:"kms_policy_arn,omitempty"`
+
+	ReplicaRegion       string `json:"replica_region,omitempty"`
+	ReplicaBucketName   string `json:"replica_bucket_name,omitempty"`
+	ReplicaKMSKeyArn    string `json:"replica_kms_key_arn,omitempty"`
+	ReplicationRoleName string `json:"replication_role_name,omitempty"`
+	ReplicationRoleArn  string `json:"replication_role_arn,omitempty"`
+	ReplicationRuleID   string `json:"replication_rule_id,omitempty"`
+	ReplicaBSLName      string `json:"replica_bsl_name,omitempty"`
+
+	Namespace    string `json:"namespace,omitempty"`
+	SecretName   string `json:"secret_name,omitempty"`
+	BSLName      string `json:"bsl_name,omitempty"`
+	ScheduleName string `json:"schedule_name,omitempty"`
+
+	CompletedSteps []string  `json:"completed_steps,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// clone returns a copy of the ledger that does not share its slices.
+func (l *Ledger) clone() *Ledger {
+	c := *l
+	c.AttachedPolicyArns = slices.Clone(l.AttachedPolicyArns)
+	c.InlinePolicyNames = slices.Clone(l.InlinePolicyNames)
+	c.CompletedSteps = slices.Clone(l.CompletedSteps)
+	return &c
+}
+
+// completed reports whether the configure step has already checkpointed its outputs.
+func (l *Ledger) completed(step string) bool {
+	return slices.Contains(l.CompletedSteps, step)
+}
+
+// markCompleted records the configure step as done.
+func (l *Ledger) markCompleted(step string) {
+	if !l.completed(step) {
+		l.CompletedSteps = append(l.CompletedSteps, step)
+	}
+}
+
+// addAttachedPolicy records a policy attached to the backup role, once.
+func (l *Ledger) addAttachedPolicy(policyArn string) {
+	if !slices.Contains(l.AttachedPolicyArns, policyArn) {
+		l.AttachedPolicyArns = append(l.AttachedPolicyArns, policyArn)
+	}
+}
+
+// removeAttachedPolicy forgets a policy detached from the backup role.
+func (l *Ledger) removeAttachedPolicy(policyArn string) {
+	l.AttachedPolicyArns = slices.DeleteFunc(l.AttachedPolicyArns, func(arn string) bool { return arn == policyArn })
+}
+
+// addInlinePolicy records an inline policy of the backup role, once.
+func (l *Ledger) addInlinePolicy(policyName string) {
+	if !slices.Contains(l.InlinePolicyNames, policyName) {
+		l.InlinePolicyNames = append(l.InlinePolicyNames, policyName)
+	}
+}
+
+// marshal renders the ledger as indented JSON, leaving characters such as < and > unescaped.
+func (l *Ledger) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(l); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// errLedgerNotFound is returned by a StateStore when no ledger exists for the cluster.
+var errLedgerNotFound = errors.New("state ledger not found")
+
+// StateStore persists the per-cluster Ledger.
+type StateStore interface {
+	Load(clusterID string) (*Ledger, error)
+	Save(ledger *Ledger) error
+	Delete(clusterID string) error
+}
+
+// ledgerFileName returns the file name a cluster's ledger is stored under.
+func ledgerFileName(clusterID string) string {
+	return fmt.Sprintf("drtest-state-%s.json", clusterID)
+}
+
+// localStateStore keeps one ledger file per cluster in a local directory.
+type localStateStore struct {
+	dir string
+}
+
+// path returns the location of a cluster's ledger file.
+func (s localStateStore) path(clusterID string) string {
+	return filepath.Join(s.dir, ledgerFileName(clusterID))
+}
+
+// Load reads the cluster's ledger file.
+func (s localStateStore) Load(clusterID string) (*Ledger, error) {
+	data, err := os.ReadFile(s.path(clusterID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errLedgerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state ledger: %w", err)
+	}
+	var ledger Ledger
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return nil, fmt.Errorf("failed to parse state ledger %s: %w", s.path(clusterID), err)
+	}
+	return &ledger, nil
+}
+
+// Save writes the cluster's ledger file, creating the state directory if needed.
+func (s localStateStore) Save(ledger *Ledger) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory %s: %w", s.dir, err)
+	}
+	data, err := ledger.marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(ledger.ClusterID), data, 0600)
+}
+
+// Delete removes the cluster's ledger file.
+func (s localStateStore) Delete(clusterID string) error {
+	err := os.Remove(s.path(clusterID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// s3StateStore keeps the ledgers as objects under drtest-state/ in an S3 bucket.
+type s3StateStore struct {
+	ctx    context.Context
+	aws    *awsClient
+	bucket string
+}
+
+// key returns the S3 key of a cluster's ledger object.
+func (s s3StateStore) key(clusterID string) string {
+	return "drtest-state/" + ledgerFileName(clusterID)
+}
+
+// Load downloads the cluster's ledger object.
+func (s s3StateStore) Load(clusterID string) (*Ledger, error) {
+	data, err := s.aws.GetObject(s.ctx, s.bucket, s.key(clusterID))
+	if err != nil {
+		if isAWSErrorCode(err, errCodeNoSuchKey) {
+			return nil, errLedgerNotFound
+		}
+		return nil, fmt.Errorf("failed to download state ledger: %w", err)
+	}
+	var ledger Ledger
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return nil, fmt.Errorf("failed to parse state ledger s3://%s/%s: %w", s.bucket, s.key(clusterID), err)
+	}
+	return &ledger, nil
+}
+
+// Save uploads the cluster's ledger object.
+func (s s3StateStore) Save(ledger *Ledger) error {
+	data, err := ledger.marshal()
+	if err != nil {
+		return err
+	}
+	if err := s.aws.PutObject(s.ctx, s.bucket, s.key(ledger.ClusterID), data); err != nil {
+		return fmt.Errorf("failed to upload state ledger: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the cluster's ledger object.
+func (s s3StateStore) Delete(clusterID string) error {
+	if err := s.aws.DeleteObject(s.ctx, s.bucket, s.key(clusterID)); err != nil {
+		return fmt.Errorf("failed to delete state ledger: %w", err)
+	}
+	return nil
+}
+
+// configMapStateStore keeps the ledgers in a ConfigMap per cluster on the management cluster.
+type configMapStateStore struct {
+	ctx       context.Context
+	kube      *kubeClient
+	namespace string
+}
+
+// configMapName returns the name of the ConfigMap holding a cluster's ledger.
+func configMapName(clusterID string) string {
+	return "drtest-state-" + clusterID
+}
+
+// Load reads the ledger from the cluster's ConfigMap.
+func (s configMapStateStore) Load(clusterID string) (*Ledger, error) {
+	cm, err := s.kube.Get(s.ctx, configMapGVR, s.namespace, configMapName(clusterID))
+	if isKubeNotFound(err) {
+		return nil, errLedgerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state ConfigMap: %w", err)
+	}
+	var ledger Ledger
+	if err := json.Unmarshal([]byte(nestedString(cm, "data", "ledger.json")), &ledger); err != nil {
+		return nil, fmt.Errorf("failed to parse state ConfigMap %s: %w", configMapName(clusterID), err)
+	}
+	return &ledger, nil
+}
+
+// Save creates or updates the cluster's ConfigMap with the ledger.
+func (s configMapStateStore) Save(ledger *Ledger) error {
+	data, err := ledger.marshal()
+	if err != nil {
+		return err
+	}
+	cm := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":      configMapName(ledger.ClusterID),
+			"namespace": s.namespace,
+		},
+		"data": map[string]any{"ledger.json": string(data)},
+	}}
+	if err := s.kube.Apply(s.ctx, cm); err != nil {
+		return fmt.Errorf("failed to apply state ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the cluster's ConfigMap.
+func (s configMapStateStore) Delete(clusterID string) error {
+	if err := s.kube.Delete(s.ctx, configMapGVR, s.namespace, configMapName(clusterID)); err != nil && !isKubeNotFound(err) {
+		return fmt.Errorf("failed to delete state ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// dryRunStateStore prints the ledger instead of persisting it.
+type dryRunStateStore struct{}
+
+// Load reports that no ledger exists, so a dry run always plans a fresh configure.
+func (dryRunStateStore) Load(clusterID string) (*Ledger, error) {
+	return nil, errLedgerNotFound
+}
+
+// Save prints the ledger.
+func (dryRunStateStore) Save(ledger *Ledger) error {
+	data, err := ledger.marshal()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[dry-run] state ledger:\n%s", data)
+	return nil
+}
+
+// Delete prints the ledger that would be deleted.
+func (dryRunStateStore) Delete(clusterID string) error {
+	fmt.Printf("[dry-run] delete state ledger of cluster %s\n", clusterID)
+	return nil
+}
+
+// stateOptions holds the flags that select where the state ledger is kept.
+type stateOptions struct {
+	Backend   string
+	Dir       string
+	Bucket    string
+	Namespace string
+}
+
+// register adds the state flags to a subcommand's flag set.
+func (o *stateOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Backend, "state-backend", "local", "where the state ledger is kept: local, s3 or configmap")
+	fs.StringVar(&o.Dir, "state-dir", ".drtest-state", "directory of the local state ledgers")
+	fs.StringVar(&o.Bucket, "state-bucket", "", "S3 bucket of the state ledgers when --state-backend=s3")
+	fs.StringVar(&o.Namespace, "state-namespace", "openshift-adp", "namespace of the state ConfigMaps when --state-backend=configmap")
+}
+
+// open returns the StateStore selected by the flags.
+func (o *stateOptions) open(ctx context.Context, dryRun bool, awsc *awsClient, kube *kubeClient) (StateStore, error) {
+	if dryRun {
+		return dryRunStateStore{}, nil
+	}
+	switch o.Backend {
+	case "local":
+		return localStateStore{dir: o.Dir}, nil
+	case "s3":
+		if o.Bucket == "" {
+			return nil, fmt.Errorf("--state-bucket is required when --state-backend=s3")
+		}
+		c, err := awsc.forBucket(ctx, o.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		return s3StateStore{ctx: ctx, aws: c, bucket: o.Bucket}, nil
+	case "configmap":
+		return configMapStateStore{ctx: ctx, kube: kube, namespace: o.Namespace}, nil
+	default:
+		return nil, fmt.Errorf("unknown --state-backend %q, expected local, s3 or configmap", o.Backend)
+	}
+}
+
+// saveLedger stamps and persists the ledger after a step recorded a new resource.
+func saveLedger(store StateStore, ledger *Ledger) error {
+	ledger.UpdatedAt = time.Now().UTC()
+	if err := store.Save(ledger); err != nil {
+		return fmt.Errorf("failed to save state ledger for cluster %s: %w", ledger.ClusterID, err)
+	}
+	return nil
+}
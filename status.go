@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// statusOptions holds the flags accepted by the status and validate subcommands.
+type statusOptions struct {
+	ClusterID string
+	Exec      executorOptions
+	State     stateOptions
+	AWS       awsOptions
+	Vault     vaultOptions
+	MCKube    kubeOptions
+}
+
+// parseStatusOptions parses the flags shared by the status and validate subcommands.
+func parseStatusOptions(name string, args []string) (statusOptions, error) {
+	var opts statusOptions
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&opts.ClusterID, "cluster-id", "", "ROSA HCP cluster ID to inspect")
+	opts.Exec.register(fs)
+	opts.State.register(fs)
+	opts.AWS.register(fs)
+	if name == "validate" {
+		// validate probes the bucket, which may live in a vault account.
+		opts.Vault.register(fs)
+	}
+	opts.MCKube.register(fs, "mc", targetMC)
+	fs.Parse(args)
+	if err := requireFlags(fs, "cluster-id"); err != nil {
+		return opts, err
+	}
+	return opts, opts.Exec.install()
+}
+
+// runStatus prints the Velero schedule, backup storage location and backups of a cluster.
+func runStatus(args []string) error {
+	opts, err := parseStatusOptions("status", args)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("--- Backup status for cluster '%s' ---\n", opts.ClusterID)
+	ctx := context.Background()
+	awsc, err := newAWSClient(ctx, defaultAWSRegion, opts.AWS, opts.Exec)
+	if err != nil {
+		return err
+	}
+	kube, err := newKubeClient(opts.MCKube, opts.Exec)
+	if err != nil {
+		return err
+	}
+	store, err := opts.State.open(ctx, opts.Exec.DryRun, awsc, kube)
+	if err != nil {
+		return err
+	}
+	ledger, err := store.Load(opts.ClusterID)
+	scheduleName := opts.ClusterID + "-hourly"
+	switch {
+	case errors.Is(err, errLedgerNotFound):
+		fmt.Println("No state ledger recorded for this cluster.")
+	case err != nil:
+		return err
+	default:
+		data, err := ledger.marshal()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("State ledger:\n%s", data)
+		scheduleName = teardownScheduleName(ledger)
+	}
+
+	schedule, err := kube.Get(ctx, scheduleGVR, veleroNamespace, scheduleName)
+	if err != nil {
+		return fmt.Errorf("failed to get schedule %s: %w", scheduleName, err)
+	}
+	fmt.Printf("\n%-40s %-12s %s\n", "SCHEDULE", "PHASE", "LAST BACKUP")
+	fmt.Printf("%-40s %-12s %s\n", schedule.GetName(), nestedString(schedule, "status", "phase"), nestedString(schedule, "status", "lastBackup"))
+
+	bsl, err := kube.Get(ctx, bslGVR, veleroNamespace, opts.ClusterID+"-hourly")
+	if err != nil {
+		return fmt.Errorf("failed to get bsl %s-hourly: %w", opts.ClusterID, err)
+	}
+	fmt.Printf("\n%-40s %-12s %-30s %s\n", "BACKUP STORAGE LOCATION", "PHASE", "LAST VALIDATED", "BUCKET")
+	fmt.Printf("%-40s %-12s %-30s %s\n", bsl.GetName(), nestedString(bsl, "status", "phase"),
+		nestedString(bsl, "status", "lastValidationTime"), nestedString(bsl, "spec", "objectStorage", "bucket"))
+
+	// Velero labels every backup with the schedule that created it, the names of other clusters'
+	// backups may contain the cluster ID too.
+	backups, err := kube.List(ctx, backupGVR, veleroNamespace, scheduleNameLabel+"="+scheduleName)
+	if err != nil {
+		return fmt.Errorf("failed to list the backups of schedule %s: %w", scheduleName, err)
+	}
+	fmt.Printf("\n%-60s %-20s %s\n", "BACKUP", "PHASE", "STARTED")
+	for _, backup := range backups {
+		fmt.Printf("%-60s %-20s %s\n", backup.GetName(), nestedString(&backup, "status", "phase"), nestedString(&backup, "status", "startTimestamp"))
+	}
+	return nil
+}
+
+// runValidate checks that the backup schedule of a cluster has been created, that its
+// backup storage location points at the region the bucket is located in, and that new objects
+// in the bucket are encrypted with the KMS key of the BSL.
+func runValidate(args []string) error {
+	opts, err := parseStatusOptions("validate", args)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	kube, err := newKubeClient(opts.MCKube, opts.Exec)
+	if err != nil {
+		return err
+	}
+	if err := validateBackupCreated(ctx, kube, opts.ClusterID); err != nil {
+		return err
+	}
+
+	bsl, err := kube.Get(ctx, bslGVR, veleroNamespace, opts.ClusterID+"-hourly")
+	if err != nil {
+		return fmt.Errorf("failed to get bsl %s-hourly: %w", opts.ClusterID, err)
+	}
+	// Only the bucket is checked, with the credentials of the account it lives in.
+	awsOpts := opts.AWS
+	if opts.Vault.set() {
+		awsOpts = opts.Vault.awsOptions(opts.AWS)
+	}
+	awsc, err := newAWSClient(ctx, defaultAWSRegion, awsOpts, opts.Exec)
+	if err != nil {
+		return err
+	}
+	bucketName := nestedString(bsl, "spec", "objectStorage", "bucket")
+	if err := verifyBucketRegion(ctx, awsc, bucketName, nestedString(bsl, "spec", "config", "region")); err != nil {
+		return err
+	}
+	keyArn := nestedString(bsl, "spec", "config", "kmsKeyId")
+	if keyArn == "" && awsc.dryRun == nil {
+		return fmt.Errorf("bsl %s-hourly has no kmsKeyId, backups are not encrypted with the cluster's KMS key", opts.ClusterID)
+	}
+	bucketClient, err := awsc.forBucket(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	return verifyBucketEncryption(ctx, bucketClient, bucketName, keyArn)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// transcriptEntry is one command captured by a recordingExecutor.
+type transcriptEntry struct {
+	Args     []string `json:"args"`
+	Stdout   string   `json:"stdout"`
+	Stderr   string   `json:"stderr"`
+	ExitCode int      `json:"exit_code"`
+}
+
+// httpExchange is one API request and its response captured by a recordingTransport.
+type httpExchange struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	RequestBody  string      `json:"request_body,omitempty"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header,omitempty"`
+	ResponseBody string      `json:"response_body,omitempty"`
+}
+
+// transcript is the content of a record/replay file, in the order the commands and requests ran.
+type transcript struct {
+	Commands []transcriptEntry `json:"commands"`
+	HTTP     []httpExchange    `json:"http,omitempty"`
+}
+
+// loadTranscript reads a transcript file written by a transcriptRecorder.
+func loadTranscript(path string) (*transcript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript %s: %w", path, err)
+	}
+	var t transcript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse transcript %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// transcriptRecorder collects the commands and HTTP exchanges of a run into one transcript file.
+// The file is rewritten after every entry so a failed run still leaves a complete record behind.
+type transcriptRecorder struct {
+	mu         sync.Mutex
+	path       string
+	transcript transcript
+}
+
+// add appends an entry to the transcript and saves it.
+func (r *transcriptRecorder) add(update func(t *transcript)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update(&r.transcript)
+	data, err := json.MarshalIndent(&r.transcript, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(r.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save transcript %s: %w", r.path, err)
+	}
+	return nil
+}
+
+// recordingExecutor runs commands through another Executor and captures the argv, stdout,
+// stderr and exit code of each one.
+type recordingExecutor struct {
+	inner    Executor
+	recorder *transcriptRecorder
+}
+
+// Run executes the command with the wrapped executor and appends it to the transcript.
+func (r *recordingExecutor) Run(name string, args ...string) (string, string, error) {
+	stdout, stderr, err := r.inner.Run(name, args...)
+
+	entry := transcriptEntry{
+		Args:   append([]string{name}, args...),
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		entry.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		// The command could not be started at all, keep the reason with the entry.
+		entry.ExitCode = -1
+		entry.Stderr += err.Error()
+	}
+
+	if saveErr := r.recorder.add(func(t *transcript) { t.Commands = append(t.Commands, entry) }); saveErr != nil {
+		return stdout, stderr, saveErr
+	}
+	return stdout, stderr, err
+}
+
+// recordingTransport sends API requests through another RoundTripper and captures each
+// request and response.
+type recordingTransport struct {
+	inner    http.RoundTripper
+	recorder *transcriptRecorder
+}
+
+// RoundTrip sends the request and appends the exchange to the transcript.
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := httpExchange{Method: req.Method, URL: req.URL.String()}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		exchange.RequestBody = redactSecretData(req, body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := r.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	exchange.Status = resp.StatusCode
+	exchange.Header = resp.Header
+	exchange.ResponseBody = redactSecretData(req, body)
+
+	if err := r.recorder.add(func(t *transcript) { t.HTTP = append(t.HTTP, exchange) }); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// redactSecretData returns the body of a Kubernetes Secret request or response with the values of
+// its data replaced, so the Velero credentials never end up in a transcript. Other bodies are
+// returned as they are.
+func redactSecretData(req *http.Request, body []byte) string {
+	if !strings.Contains(req.URL.Path, "/secrets") {
+		return string(body)
+	}
+	var obj map[string]any
+	if json.Unmarshal(body, &obj) != nil {
+		return string(body)
+	}
+	redact := func(obj map[string]any) {
+		if data, ok := obj["data"].(map[string]any); ok {
+			for key := range data {
+				data[key] = "<redacted>"
+			}
+		}
+	}
+	redact(obj)
+	if items, ok := obj["items"].([]any); ok {
+		for _, item := range items {
+			if item, ok := item.(map[string]any); ok {
+				redact(item)
+			}
+		}
+	}
+	redacted, err := json.Marshal(obj)
+	if err != nil {
+		return string(body)
+	}
+	return string(redacted)
+}
+
+// replayExecutor serves the commands of a transcript back in order without running anything.
+// A command that differs from the next recorded one fails the run.
+type replayExecutor struct {
+	mu      sync.Mutex
+	entries []transcriptEntry
+	next    int
+}
+
+// replayExitError reports the non-zero exit code of a replayed command.
+type replayExitError struct {
+	code int
+}
+
+func (e *replayExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// Run returns the recorded output of the next command in the transcript.
+func (r *replayExecutor) Run(name string, args ...string) (string, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fmt.Printf("Replaying command: %s\n", formatCommand(name, args))
+	argv := append([]string{name}, args...)
+	if r.next >= len(r.entries) {
+		return "", "", fmt.Errorf("replay: unexpected command after end of transcript: %s", formatCommand(name, args))
+	}
+	entry := r.entries[r.next]
+	if len(entry.Args) == 0 {
+		return "", "", fmt.Errorf("replay: command #%d of the transcript has no arguments", r.next+1)
+	}
+	if !slices.Equal(entry.Args, argv) {
+		return "", "", fmt.Errorf("replay: command #%d mismatch\n  recorded: %s\n  got:      %s",
+			r.next+1, formatCommand(entry.Args[0], entry.Args[1:]), formatCommand(name, args))
+	}
+	r.next++
+
+	if entry.ExitCode != 0 {
+		return entry.Stdout, entry.Stderr, &replayExitError{code: entry.ExitCode}
+	}
+	return entry.Stdout, entry.Stderr, nil
+}
+
+// replayTransport serves recorded API responses without sending anything.
+// A request is answered by the first unused exchange with the same method and URL, which keeps
+// replay working when independent requests run concurrently; request bodies are not compared
+// because they may carry timestamps.
+type replayTransport struct {
+	mu        sync.Mutex
+	exchanges []httpExchange
+	used      []bool
+}
+
+// RoundTrip returns the recorded response for the request.
+func (r *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	url := req.URL.String()
+	for i, exchange := range r.exchanges {
+		if r.used[i] || exchange.Method != req.Method || exchange.URL != url {
+			continue
+		}
+		r.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+			StatusCode:    exchange.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        exchange.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader([]byte(exchange.ResponseBody))),
+			ContentLength: int64(len(exchange.ResponseBody)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("replay: no recorded response left for %s %s", req.Method, url)
+}
+
+// httpTransport carries the HTTP traffic of the API clients. It is swapped for a recording
+// or replaying transport by --record and --replay.
+var httpTransport http.RoundTripper = http.DefaultTransport
+
+// executorOptions holds the flags that select the Executor of a subcommand.
+type executorOptions struct {
+	DryRun bool
+	Record string
+	Replay string
+}
+
+// register adds the executor flags to a subcommand's flag set.
+func (o *executorOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.DryRun, "dry-run", false, "print the command plan without touching AWS or the cluster")
+	fs.StringVar(&o.Record, "record", "", "record every command, API call and their output to this transcript file")
+	fs.StringVar(&o.Replay, "replay", "", "serve every command and API call from this transcript file instead of running it")
+}
+
+// install replaces the package level executor and HTTP transport according to the selected flags.
+func (o *executorOptions) install() error {
+	set := 0
+	for _, on := range []bool{o.DryRun, o.Record != "", o.Replay != ""} {
+		if on {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("--dry-run, --record and --replay are mutually exclusive")
+	}
+
+	switch {
+	case o.DryRun:
+		executor = &dryRunExecutor{}
+	case o.Record != "":
+		recorder := &transcriptRecorder{path: o.Record}
+		executor = &recordingExecutor{inner: executor, recorder: recorder}
+		httpTransport = &recordingTransport{inner: httpTransport, recorder: recorder}
+	case o.Replay != "":
+		t, err := loadTranscript(o.Replay)
+		if err != nil {
+			return err
+		}
+		executor = &replayExecutor{entries: t.Commands}
+		httpTransport = &replayTransport{exchanges: t.HTTP, used: make([]bool, len(t.HTTP))}
+	}
+	return nil
+}
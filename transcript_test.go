@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the replay tests")
+
+// runCaptured runs a subcommand with the default executor and HTTP transport and returns
+// everything it printed.
+func runCaptured(t *testing.T, run func(args []string) error, args ...string) string {
+	t.Helper()
+	savedExecutor, savedTransport := executor, httpTransport
+	t.Cleanup(func() { executor, httpTransport = savedExecutor, savedTransport })
+	executor, httpTransport = execExecutor{}, http.DefaultTransport
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	savedStdout, savedStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = out, out
+	runErr := run(args)
+	os.Stdout, os.Stderr = savedStdout, savedStderr
+	output, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runErr != nil {
+		t.Fatalf("%v\noutput:\n%s", runErr, output)
+	}
+	return string(output)
+}
+
+// replayRun runs a subcommand with --replay against a transcript in testdata and returns
+// everything it printed.
+func replayRun(t *testing.T, run func(args []string) error, transcript string, args ...string) string {
+	t.Helper()
+	return runCaptured(t, run, append(args, "--replay", filepath.Join("testdata", transcript))...)
+}
+
+// checkGolden compares the output of a run with testdata/<name>.golden, or rewrites the file
+// with -update.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -update to create it", err)
+	}
+	if got != string(want) {
+		t.Errorf("output of %s differs from %s, run go test -update and review the diff\ngot:\n%s", name, path, got)
+	}
+}
+
+// replayArgs returns the flags shared by the recorded configure and teardown runs, with a state
+// directory of their own, and keeps the developer's AWS and OCM configuration out of the replay.
+func replayArgs(t *testing.T, stateDir string) []string {
+	t.Helper()
+	pollInterval := deleteRequestPollInterval
+	deleteRequestPollInterval = 0
+	t.Cleanup(func() { deleteRequestPollInterval = pollInterval })
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "aws-credentials"))
+	t.Setenv("OCM_CONFIG", filepath.Join(t.TempDir(), "ocm.json"))
+	return []string{
+		"--cluster-id", "abc",
+		"--mc-name", "mc",
+		"--aws-profile", "p",
+		"--aws-endpoint-url", "http://127.0.0.1:4566",
+		"--mc-kubeconfig", filepath.Join("testdata", "kubeconfig"),
+		"--mc-context", "mc",
+		"--ocm-url", "http://127.0.0.1:4567",
+		"--state-dir", stateDir,
+	}
+}
+
+// loadTestLedger reads a ledger file without its timestamp, which differs on every run.
+func loadTestLedger(t *testing.T, path string) Ledger {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ledger Ledger
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+	ledger.UpdatedAt = time.Time{}
+	return ledger
+}
+
+// TestReplayConfigure replays a recorded configure run through runConfigure. The ledger it saves
+// must be the one the teardown replay starts from.
+func TestReplayConfigure(t *testing.T) {
+	stateDir := t.TempDir()
+	args := append(replayArgs(t, stateDir), "--cluster-name", "n", "--cluster-env", "int", "--region", "us-west-2")
+	checkGolden(t, "configure", replayRun(t, runConfigure, "configure.transcript.json", args...))
+
+	got := loadTestLedger(t, filepath.Join(stateDir, ledgerFileName("abc")))
+	if want := loadTestLedger(t, filepath.Join("testdata", "teardown.ledger.json")); !reflect.DeepEqual(got, want) {
+		t.Errorf("configure saved ledger %+v, want testdata/teardown.ledger.json %+v", got, want)
+	}
+}
+
+// TestReplayTeardown replays a recorded teardown through runTeardown, starting from the ledger in
+// testdata/teardown.ledger.json.
+func TestReplayTeardown(t *testing.T) {
+	stateDir := t.TempDir()
+	ledger, err := os.ReadFile(filepath.Join("testdata", "teardown.ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, ledgerFileName("abc")), ledger, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	args := append(replayArgs(t, stateDir), "--yes")
+	checkGolden(t, "teardown", replayRun(t, runTeardown, "teardown.transcript.json", args...))
+
+	if _, err := os.Stat(filepath.Join(stateDir, ledgerFileName("abc"))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the ledger of a completed teardown is kept: %v", err)
+	}
+}
+
+func TestReplayExecutor(t *testing.T) {
+	r := &replayExecutor{entries: []transcriptEntry{
+		{Args: []string{"rosa", "describe", "cluster"}, Stdout: "ready", ExitCode: 0},
+		{Args: []string{"uuidgen"}, ExitCode: 1},
+		{},
+	}}
+	if stdout, _, err := r.Run("rosa", "describe", "cluster"); err != nil || stdout != "ready" {
+		t.Fatalf("Run(rosa) = %q, %v, want the recorded output", stdout, err)
+	}
+	var exitErr *replayExitError
+	if _, _, err := r.Run("uuidgen"); !errors.As(err, &exitErr) || exitErr.code != 1 {
+		t.Fatalf("Run(uuidgen) error = %v, want exit status 1", err)
+	}
+	// An entry without arguments cannot be matched, it fails the run instead of panicking.
+	if _, _, err := r.Run("oc", "whoami"); err == nil || !strings.Contains(err.Error(), "has no arguments") {
+		t.Fatalf("Run of an empty entry: got %v, want an error", err)
+	}
+	if _, _, err := r.Run("oc", "whoami"); err == nil || !strings.Contains(err.Error(), "has no arguments") {
+		t.Fatalf("Run after an empty entry: got %v, want the same error", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// vaultOptions holds the flags that select the vault account, the account the backup buckets and
+// KMS keys are created in. The OIDC provider and the backup role stay in the account of --aws-profile.
+type vaultOptions struct {
+	Profile       string
+	AssumeRoleArn string
+	ExternalID    string
+}
+
+// register adds the vault account flags to a subcommand's flag set.
+func (o *vaultOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Profile, "vault-profile", "", "AWS profile of the vault account that holds the backup bucket and KMS key; defaults to the account of --aws-profile")
+	fs.StringVar(&o.AssumeRoleArn, "vault-assume-role-arn", "", "role in the vault account to assume with the credentials of --vault-profile, or of --aws-profile without it")
+	fs.StringVar(&o.ExternalID, "vault-external-id", "", "external ID required by the trust policy of --vault-assume-role-arn")
+}
+
+// set reports whether a vault account was selected.
+func (o vaultOptions) set() bool {
+	return o.Profile != "" || o.AssumeRoleArn != ""
+}
+
+// awsOptions returns the client options of the vault account. Without --vault-profile the role
+// is assumed with the credentials of the source account, and the endpoint is always shared.
+func (o vaultOptions) awsOptions(source awsOptions) awsOptions {
+	opts := awsOptions{Profile: o.Profile, AssumeRoleArn: o.AssumeRoleArn, ExternalID: o.ExternalID, Endpoint: source.Endpoint}
+	if opts.Profile == "" {
+		opts.Profile = source.Profile
+	}
+	return opts
+}
+
+// awsVault is the account the backup buckets and KMS keys live in, with its caller identity.
+type awsVault struct {
+	*awsClient
+	account   string
+	callerArn string
+}
+
+// openVault returns the vault account selected by the vault flags. Without them the vault is the
+// source account: source, account and callerArn are what PrintIdentity returned for it.
+func openVault(ctx context.Context, source *awsClient, account, callerArn string, opts vaultOptions, execOpts executorOptions) (*awsVault, error) {
+	if !opts.set() {
+		return &awsVault{awsClient: source, account: account, callerArn: callerArn}, nil
+	}
+	c, err := newAWSClient(ctx, source.cfg.Region, opts.awsOptions(source.opts), execOpts)
+	if err != nil {
+		return nil, fmt.Errorf("vault account: %w", err)
+	}
+	fmt.Println("Backup vault:")
+	vaultAccount, vaultCallerArn, err := c.PrintIdentity(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("vault account: %w", err)
+	}
+	return &awsVault{awsClient: c, account: vaultAccount, callerArn: vaultCallerArn}, nil
+}
+
+// checkVaultAccount makes sure the vault is the account a ledger recorded the buckets and keys in.
+func checkVaultAccount(ledger *Ledger, vault *awsVault) error {
+	if ledger.VaultAccountID == "" || vault.dryRun != nil || ledger.VaultAccountID == vault.account {
+		return nil
+	}
+	return fmt.Errorf("the backup bucket of cluster %s is in vault account %s, not in %s: pass --vault-profile or --vault-assume-role-arn for that account",
+		ledger.ClusterID, ledger.VaultAccountID, vault.account)
+}
+
+// grantVaultBucketAccess adds the statements that let roleArn of another account use the backup
+// prefix of a vault bucket to its bucket policy, keeping the other statements. With readOnly the
+// role may only read and list, as the replica BSL does.
+func grantVaultBucketAccess(ctx context.Context, c *awsClient, bucket, roleArn string, readOnly bool) error {
+	policy, err := c.BucketPolicy(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to get the bucket policy of %s: %w", bucket, err)
+	}
+	if c.dryRun != nil {
+		policy = ""
+	}
+	merged, err := mergePolicyStatements(policy, crossAccountBucketStatements(bucket, roleArn, readOnly))
+	if err != nil {
+		return err
+	}
+	if err := c.PutBucketPolicy(ctx, bucket, merged); err != nil {
+		return fmt.Errorf("failed to put the bucket policy of %s: %w", bucket, err)
+	}
+	fmt.Printf("Bucket policy of '%s' grants role '%s' access to %s/.\n", bucket, roleArn, backupPrefix)
+	return nil
+}